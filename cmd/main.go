@@ -1,11 +1,11 @@
 package main
 
 import (
-	"strings"
-
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 	"github.com/slim-ai/mob-code-server/pkg/config"
 	"github.com/slim-ai/mob-code-server/pkg/crypto"
+	"github.com/slim-ai/mob-code-server/pkg/health"
+	"github.com/slim-ai/mob-code-server/pkg/ready"
 	"github.com/slim-ai/mob-code-server/pkg/server"
 	"github.com/slim-ai/mob-code-server/pkg/userdata"
 )
@@ -29,53 +29,57 @@ func main() {
 		if err := crypto.TryCreateMachineSshCertificate(&settings); err != nil {
 			return err
 		}
-		/////////////////////////////////////////////////////////////
-		// Helper method to resolve variables from config
-		// and from generated values
-		variableResolver := func(script string) string {
-			// Email address for FQDN certificate create/renewal
-			script = strings.ReplaceAll(script, "___EMAIL__ADDRESS___", settings.Email)
-			script = strings.ReplaceAll(script, "___USERNAME___", settings.MachineInfo.UserName)
-			script = strings.ReplaceAll(script, "___HOSTNAME___", settings.MachineInfo.Hostname)
-			script = strings.ReplaceAll(script, "___DOMAIN_NAME___", settings.DomainName)
-			if settings.Gitlab.Enabled {
-				// For preloading repositories from gitlab
-				script = strings.ReplaceAll(script, "___GITLAB_TOKEN___", settings.Gitlab.Token)
-				script = strings.ReplaceAll(script, "___GITLAB_REPOS___", strings.Join(settings.Gitlab.Repositories, ","))
-			}
-			if settings.Github.Enabled {
-				// For preloading repositories from gitlab
-				script = strings.ReplaceAll(script, "___GITHUB_TOKEN___", settings.Gitlab.Token)
-				script = strings.ReplaceAll(script, "___GITHUB_REPOS___", strings.Join(settings.Gitlab.Repositories, ","))
-			}
-			for key, value := range settings.ExtraVariables { // replace any user provided
-				script = strings.ReplaceAll(script, key, value)
-			}
-			return script
-		}
-
 		//
 		////////////////////////////////////////////////////////////
 		// Get current version of code server installation script
-		userDataScript, err := userdata.BuildUserData(&settings, variableResolver)
+		userData, err := userdata.BuildUserData(&settings)
+		if err != nil {
+			return err
+		}
+		userDataBase64, err := userData.Encode()
+		if err != nil {
+			return err
+		}
+		steps, err := userdata.PlanProvisioning(&settings)
 		if err != nil {
 			return err
 		}
+		// Refuse to deploy broken scripts, even on preview
+		if err := userdata.CheckScripts(userData, steps); err != nil {
+			return err
+		}
 		//
 		////////////////////////////////////////////////////////////
-		inst, err := server.CreateNewInstance(ctx, &settings, hostedZone, &userDataScript)
+		inst, publicIp, err := server.CreateNewInstance(ctx, &settings, hostedZone, &userDataBase64)
+		if err != nil {
+			return err
+		}
+		// Wait for ssh and cloud-init, connecting by IP as the DNS
+		// record may not have propagated yet
+		host, err := ready.Wait(ctx, &settings, publicIp)
 		if err != nil {
 			return err
 		}
 		// Finally run any one shot provisioning
-		if err := userdata.RunProvisioningScripts(ctx,
+		provisioning, err := userdata.RunProvisioningScripts(ctx,
 			&settings,
+			host,
+			steps,
 			[]pulumi.Resource{inst},
-			variableResolver, // Seed w/ same variables
-		); err != nil {
+		)
+		if err != nil {
+			return err
+		}
+		// Then check that it all came up, again when the machine or the
+		// steps change
+		checked := pulumi.Array{inst.(pulumi.CustomResource).ID(), pulumi.String(userdata.Fingerprint(steps))}
+		report, err := health.Run(ctx, &settings, host, provisioning, checked, []pulumi.Resource{inst})
+		if err != nil {
 			return err
 		}
 		ctx.Export("dns_name", pulumi.String(settings.DomainName))
+		ctx.Export("provisioning", provisioning)
+		ctx.Export("health", report)
 		return nil
 	})
 }
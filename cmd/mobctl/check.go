@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/slim-ai/mob-code-server/pkg/userdata"
+)
+
+func runCheck(args []string) error {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile, "pulumi configuration file")
+	flags.Parse(args)
+
+	settings, err := loadSettings(*configFile)
+	if err != nil {
+		return err
+	}
+	printWarnings(settings.Warnings)
+	userData, err := userdata.BuildUserData(settings)
+	if err != nil {
+		return err
+	}
+	steps, err := userdata.PlanProvisioning(settings)
+	if err != nil {
+		return err
+	}
+	if err := userdata.CheckScripts(userData, steps); err != nil {
+		return err
+	}
+	if _, err := userData.Encode(); err != nil {
+		return err
+	}
+	fmt.Print(userdata.Graph(steps))
+	fmt.Printf("%d provisioning steps checked\n", len(steps))
+	return nil
+}
@@ -0,0 +1,146 @@
+// mobctl is a local helper for working with the mob-server configuration
+// and scripts without deploying anything.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"check": {
+		usage: "check the rendered scripts for syntax errors and unresolved names",
+		run:   runCheck,
+	},
+	"schema": {
+		usage: "write the JSON Schema of the configuration file",
+		run:   runSchema,
+	},
+	"explain": {
+		usage: "show the effective settings and the layer each one came from",
+		run:   runExplain,
+	},
+	"migrate": {
+		usage: "upgrade the configuration file to the current settings version",
+		run:   runMigrate,
+	},
+	"render": {
+		usage: "write the resolved user-data and provisioning scripts to a directory",
+		run:   runRender,
+	},
+}
+
+// defaultConfigFile is relative to cmd/, where pulumi runs the program
+const defaultConfigFile = "../config/configuration.yml"
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		printUsage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "mobctl %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: mobctl <command> [flags]")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+func runSchema(args []string) error {
+	flags := flag.NewFlagSet("schema", flag.ExitOnError)
+	out := flags.String("out", "", "file to write, defaults to standard out")
+	flags.Parse(args)
+
+	schema, err := config.Schema()
+	if err != nil {
+		return err
+	}
+	schema = append(schema, '\n')
+	if *out == "" {
+		_, err = os.Stdout.Write(schema)
+		return err
+	}
+	return os.WriteFile(*out, schema, 0644)
+}
+
+// loadSettings layers the configuration file the same way a deployment
+// layers the stack configuration
+func loadSettings(configFile string) (*config.Settings, error) {
+	settings := &config.Settings{Region: os.Getenv("AWS_REGION")}
+	file, err := config.FileLayer("file", configFile)
+	if err != nil {
+		return nil, err
+	}
+	env, err := config.EnvLayer(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	return settings, settings.LoadLayers(file, env)
+}
+
+func runExplain(args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile, "pulumi configuration file")
+	flags.Parse(args)
+
+	settings, err := loadSettings(*configFile)
+	if settings == nil {
+		return err
+	}
+	// still explain invalid settings, they are the ones needing it
+	settings.Explain(os.Stdout)
+	printWarnings(settings.Warnings)
+	return err
+}
+
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile, "pulumi configuration file")
+	write := flags.Bool("write", false, "rewrite the file in place, keeping a .bak copy")
+	flags.Parse(args)
+
+	warnings, changed, err := config.MigrateFile(*configFile, *write)
+	if err != nil {
+		return err
+	}
+	printWarnings(warnings)
+	switch {
+	case !changed:
+		fmt.Printf("%s is already at settings version %d\n", *configFile, config.CurrentVersion)
+	case *write:
+		fmt.Printf("%s upgraded to settings version %d\n", *configFile, config.CurrentVersion)
+	default:
+		fmt.Printf("%s needs upgrading to settings version %d, run again with --write\n", *configFile, config.CurrentVersion)
+	}
+	return nil
+}
+
+func printWarnings(warnings []string) {
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/slim-ai/mob-code-server/pkg/userdata"
+)
+
+func runRender(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile, "pulumi configuration file")
+	out := flags.String("out", "../rendered", "directory to write the scripts to")
+	showSecrets := flags.Bool("show-secrets", false, "write tokens and keys instead of masking them")
+	flags.Parse(args)
+
+	settings, err := loadSettings(*configFile)
+	if err != nil {
+		return err
+	}
+	printWarnings(settings.Warnings)
+	userData, err := userdata.BuildUserData(settings)
+	if err != nil {
+		return err
+	}
+	steps, err := userdata.PlanProvisioning(settings)
+	if err != nil {
+		return err
+	}
+
+	mask := strings.NewReplacer()
+	mode := os.FileMode(0644)
+	if *showSecrets {
+		mode = 0600
+	} else {
+		mask = settings.MaskSecrets()
+	}
+	document, err := userData.MIME()
+	if err != nil {
+		return err
+	}
+	files := map[string]string{"userdata.mime": string(document)}
+	for i, part := range userData.Parts {
+		files[filepath.Join("userdata", fmt.Sprintf("%02d-%s", i+1, part.Name))] = part.Content
+	}
+	for i, step := range steps {
+		for _, file := range step.Files {
+			files[filepath.Join("files", fmt.Sprintf("%02d-%s", i+1, step.Name), filepath.FromSlash(file.Destination))] = file.Content
+		}
+		if step.Create == "" {
+			continue
+		}
+		files[filepath.Join("up", fmt.Sprintf("%02d-%s", i+1, step.CreateName()))] = step.Create
+		if step.Down != "" {
+			files[filepath.Join("down", fmt.Sprintf("%02d-%s", i+1, step.DeleteName()))] = step.Delete
+		}
+	}
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(*out, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(mask.Replace(files[name])), mode); err != nil {
+			return err
+		}
+		fmt.Println(path)
+	}
+	return nil
+}
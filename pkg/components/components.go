@@ -0,0 +1,184 @@
+// Package components is the registry of the toolchains installed on the
+// machine, such as go, nvm or pulumi. Each component has install (and
+// optionally uninstall) scripts, found in common/components/<name>/ of the
+// scripts tree unless overridden in <dist>/components/<name>/, and may
+// depend on others.
+package components
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var ErrUnknownComponent = errors.New("unknown component")
+
+// Component is an installable toolchain
+type Component struct {
+	Name            string
+	Description     string
+	VersionVariable string // the ___NAME___ placeholder of the version in its scripts, if versioned
+	DefaultVersion  string
+	Requires        []string
+}
+
+// Selection is a component picked for a machine, at a version
+type Selection struct {
+	Component
+	Version string
+}
+
+var registry = map[string]Component{}
+
+// Defaults are the components installed when settings.components is not set
+var Defaults = []string{
+	"docker-compose",
+	"go",
+	"terraform",
+	"serverless",
+	"nvm",
+	"aws-cli",
+	"pulumi",
+	"git-secret",
+	"session-manager-plugin",
+	"regctl",
+}
+
+func init() {
+	for _, component := range []Component{
+		{Name: "aws-cli", Description: "AWS command line interface v2"},
+		{Name: "docker-compose", Description: "docker-compose shim over the docker compose plugin"},
+		{Name: "git-secret", Description: "git-secret, built from source"},
+		{Name: "go", Description: "Go toolchain", VersionVariable: "GOLANG_VERSION", DefaultVersion: "1.19.5"},
+		{Name: "nvm", Description: "Node version manager", VersionVariable: "NVM_VERSION", DefaultVersion: "0.39.3"},
+		{Name: "pulumi", Description: "Pulumi command line interface"},
+		{Name: "regctl", Description: "OCI registry client", Requires: []string{"go"}},
+		{Name: "serverless", Description: "Serverless framework", VersionVariable: "SERVERLESS_VERSION", DefaultVersion: "2.64.1"},
+		{Name: "session-manager-plugin", Description: "AWS session manager plugin"},
+		{Name: "terraform", Description: "terraform-switcher, to install terraform versions"},
+	} {
+		Register(component)
+	}
+}
+
+// Register adds or replaces a component
+func Register(component Component) {
+	registry[component.Name] = component
+}
+
+// Names returns the registered component names, sorted
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// versionPattern keeps versions safe to write in scripts
+var versionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// Parse parses a requested component, eg. go or go@1.20.4, returning an
+// empty version when not given
+func Parse(request string) (Component, string, error) {
+	name, version := request, ""
+	if i := strings.Index(request, "@"); i >= 0 {
+		name, version = request[:i], request[i+1:]
+	}
+	component, ok := registry[name]
+	if !ok {
+		return Component{}, "", fmt.Errorf("%w %q, expected one of %s", ErrUnknownComponent, name, strings.Join(Names(), ", "))
+	}
+	if version == "" && strings.Contains(request, "@") || version != "" && !versionPattern.MatchString(version) {
+		return Component{}, "", fmt.Errorf("invalid version %q for %s", version, name)
+	}
+	if version != "" && component.VersionVariable == "" {
+		return Component{}, "", fmt.Errorf("%s is not versioned, it always installs the latest release", name)
+	}
+	return component, version, nil
+}
+
+// Resolve returns the requested components and the ones they need, each
+// after its requirements. The required components are added when not
+// requested. A version is taken from the request, then from the variables
+// (eg. GOLANG_VERSION), then from the component default.
+func Resolve(requested []string, required []string, variables map[string]string) ([]Selection, error) {
+	versions := map[string]string{}
+	order := []string{}
+	for _, request := range requested {
+		component, version, err := Parse(request)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := versions[component.Name]; ok {
+			return nil, fmt.Errorf("%s is listed more than once", component.Name)
+		}
+		versions[component.Name] = version
+		order = append(order, component.Name)
+	}
+	order = append(order, required...)
+
+	selections := []Selection{}
+	state := map[string]int{} // 1 while visiting its requirements, 2 once added
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		component, ok := registry[name]
+		if !ok {
+			return fmt.Errorf("%w %q, needed by %s", ErrUnknownComponent, name, strings.Join(path, " -> "))
+		}
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("components depend on each other: %s -> %s", strings.Join(path, " -> "), name)
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		path = append(append([]string{}, path...), name)
+		for _, requirement := range component.Requires {
+			if err := visit(requirement, path); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		selection := Selection{Component: component, Version: versions[name]}
+		if selection.Version == "" && component.VersionVariable != "" {
+			selection.Version = component.DefaultVersion
+			if value, ok := variables[component.VersionVariable]; ok && value != "" {
+				selection.Version = value
+			}
+		}
+		selections = append(selections, selection)
+		return nil
+	}
+	for _, name := range order {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return selections, nil
+}
+
+// Scripts returns the install and uninstall scripts of the component in
+// the scripts tree, from the directory of the distribution then from
+// common, the uninstall one is empty when there is none
+func (component Component) Scripts(fsys fs.FS, distDir string) (install string, uninstall string, err error) {
+	find := func(file string) string {
+		for _, dir := range []string{distDir, "common"} {
+			name := path.Join(dir, "components", component.Name, file)
+			if _, err := fs.Stat(fsys, name); err == nil {
+				return name
+			}
+		}
+		return ""
+	}
+	if install = find("install.sh"); install == "" {
+		return "", "", fmt.Errorf("no install.sh for component %s in %s", component.Name,
+			path.Join("common", "components", component.Name))
+	}
+	return install, find("uninstall.sh"), nil
+}
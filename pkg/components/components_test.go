@@ -0,0 +1,117 @@
+package components
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/slim-ai/mob-code-server/scripts"
+)
+
+func TestResolve(t *testing.T) {
+	Register(Component{Name: "test-a", Requires: []string{"test-b"}})
+	Register(Component{Name: "test-b", Requires: []string{"test-a"}})
+	defer delete(registry, "test-a")
+	defer delete(registry, "test-b")
+
+	testCases := []struct {
+		name        string
+		requested   []string
+		required    []string
+		variables   map[string]string
+		expected    []string
+		expectedErr string
+	}{
+		{
+			name:      "requirements first",
+			requested: []string{"regctl", "pulumi"},
+			expected:  []string{"go@1.19.5", "regctl", "pulumi"},
+		},
+		{
+			name:      "requested version",
+			requested: []string{"regctl", "go@1.20.4"},
+			variables: map[string]string{"GOLANG_VERSION": "1.18"},
+			expected:  []string{"go@1.20.4", "regctl"},
+		},
+		{
+			name:      "version from the variables",
+			requested: []string{"nvm"},
+			required:  []string{"go"},
+			variables: map[string]string{"GOLANG_VERSION": "1.18"},
+			expected:  []string{"nvm@0.39.3", "go@1.18"},
+		},
+		{
+			name:     "nothing requested",
+			required: []string{"go"},
+			expected: []string{"go@1.19.5"},
+		},
+		{
+			name:        "unknown component",
+			requested:   []string{"rust"},
+			expectedErr: `unknown component "rust"`,
+		},
+		{
+			name:        "unversioned component",
+			requested:   []string{"pulumi@3.0.0"},
+			expectedErr: "pulumi is not versioned",
+		},
+		{
+			name:        "malformed version",
+			requested:   []string{"go@$(reboot)"},
+			expectedErr: "invalid version",
+		},
+		{
+			name:        "listed twice",
+			requested:   []string{"go", "go@1.20.4"},
+			expectedErr: "go is listed more than once",
+		},
+		{
+			name:        "cycle",
+			requested:   []string{"test-a"},
+			expectedErr: "components depend on each other: test-a -> test-b -> test-a",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			selections, err := Resolve(tc.requested, tc.required, tc.variables)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected an error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			actual := []string{}
+			for _, selection := range selections {
+				if selection.Version != "" {
+					actual = append(actual, selection.Name+"@"+selection.Version)
+				} else {
+					actual = append(actual, selection.Name)
+				}
+			}
+			if strings.Join(actual, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	for _, name := range Defaults {
+		component, _, err := Parse(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, distDir := range []string{"ubuntu", "amazon-linux"} {
+			if _, _, err := component.Scripts(scripts.FS, distDir); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+	}
+	if _, _, err := Parse("rust"); !errors.Is(err, ErrUnknownComponent) {
+		t.Errorf("expected ErrUnknownComponent, got %v", err)
+	}
+}
@@ -2,93 +2,288 @@ package config
 
 import (
 	"encoding/base64"
-	"errors"
 	"fmt"
+	"os"
+	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+	"github.com/slim-ai/mob-code-server/pkg/distro"
+	"github.com/slim-ai/mob-code-server/pkg/secrets"
 )
 
 type Settings struct {
-	DomainName     string                       `yaml:"_" json:"_"`         // computed
-	Email          string                       `yaml:"email" json:"email"` // populated when we init the CVS certs
-	HostedZone     string                       `yaml:"hosted_zone" json:"hosted_zone"`
-	VpcId          string                       `yaml:"vpc_id" json:"vpc_id"`
+	Version        int                          `yaml:"version" json:"version" validate:"min=0"`             // format version, see CurrentVersion
+	DomainName     string                       `yaml:"_" json:"_"`                                          // computed
+	Email          string                       `yaml:"email" json:"email" validate:"required,format=email"` // populated when we init the CVS certs
+	HostedZone     string                       `yaml:"hosted_zone" json:"hosted_zone" validate:"required,format=dns-name"`
+	VpcId          string                       `yaml:"vpc_id" json:"vpc_id" validate:"format=vpc-id"`
 	MachineInfo    MachineInfo                  `yaml:"instance" json:"instance"`
 	Gitlab         ConcurrentVersionsSystemInfo `yaml:"gitlab" json:"gitlab"`
 	Github         ConcurrentVersionsSystemInfo `yaml:"github" json:"github"`
 	ExtraVariables map[string]string            `yaml:"variables" json:"variables"`
+	Team           []TeamMember                 `yaml:"team" json:"team"`                              // developers sharing the server
+	DefaultsFile   string                       `yaml:"defaults_file" json:"defaults_file"`            // org-wide defaults, merged under the stack settings
+	Tags           map[string]string            `yaml:"tags" json:"tags"`                              // added to the AWS resources, see ResourceTags
+	Expires        string                       `yaml:"expires" json:"expires" validate:"format=date"` // tagged as Expiry on the AWS resources
+	PolicyFile     string                       `yaml:"policy_file" json:"policy_file"`                // guardrails, see Policy
+	Components     []string                     `yaml:"components" json:"components"`                  // toolchains to install, eg. go@1.20.4, all when not set
+	ForceStep      string                       `yaml:"force_step" json:"force_step"`                  // provisioning step re-run on every deploy, eg. MOB_FORCE_STEP=git.sh
+	ResumeFrom     string                       `yaml:"resume_from" json:"resume_from"`                // provisioning step re-run, the ones before it counted as completed
+	ScriptsDir     string                       `yaml:"scripts_dir" json:"scripts_dir"`                // overrides or adds to the built-in scripts
+	Health         HealthInfo                   `yaml:"health" json:"health"`                          // checks run after provisioning
+	Policy         *Policy                      `yaml:"-" json:"-"`                                    // computed, read from PolicyFile
+	Region         string                       `yaml:"-" json:"-"`                                    // computed, aws:region
+	Project        string                       `yaml:"-" json:"-"`                                    // computed, pulumi project
+	Stack          string                       `yaml:"-" json:"-"`                                    // computed, pulumi stack
+	Origins        map[string]string            `yaml:"-" json:"-"`                                    // computed, layer name by setting path
+	Warnings       []string                     `yaml:"-" json:"-"`                                    // computed, deprecated settings found while loading
 }
 
 type MachineInfo struct {
-	ResourceType   string         `yaml:"resource_type" json:"resource_type"`
+	ResourceType   string         `yaml:"resource_type" json:"resource_type" validate:"enum=spot|ec2"`
 	AmiId          string         `yaml:"-" json:"-"`
 	SubnetId       string         `yaml:"-" json:"-"`
-	Hostname       string         `yaml:"hostname" json:"hostname"`
-	UserName       string         `yaml:"username" json:"username"`
-	InstanceType   string         `yaml:"instance_type" json:"instance_type"`
-	OfferSpotPrice string         `yaml:"spot_price" json:"spot_price"`
+	Hostname       string         `yaml:"hostname" json:"hostname" validate:"required,format=dns-label"`
+	UserName       string         `yaml:"username" json:"username" validate:"format=unix-user"`
+	LoginUser      string         `yaml:"login_user" json:"login_user" validate:"format=unix-user"` // ssh user of the AMI, set from os_dist
+	InstanceType   string         `yaml:"instance_type" json:"instance_type" validate:"format=instance-type"`
+	OfferSpotPrice string         `yaml:"spot_price" json:"spot_price" validate:"format=price"`
 	SpotPrice      string         `yaml:"-" json:"-"`
-	OsDist         string         `yaml:"os_dist" json:"os_dist"`
-	DiskSizeGB     int            `yaml:"disk_size" json:"disk_size"`
+	OsDist         string         `yaml:"os_dist" json:"os_dist"` // see distro.Names
+	DiskSizeGB     int            `yaml:"disk_size" json:"disk_size" validate:"min=8,max=16384"`
 	Credentials    SshCredentials `yaml:"credentials" json:"credentials"`
 }
 
 type ConcurrentVersionsSystemInfo struct {
 	Enabled      bool     `yaml:"enabled" json:"enabled"`
-	Token        string   `yaml:"token" json:"token"`
+	Token        string   `yaml:"token" json:"token" secret:"true"`
 	Repositories []string `yaml:"repositories" json:"repositories"`
 	Username     string   `yaml:"username" json:"username"`
 }
 
+// TeamMember is a developer given their own account, code-server
+// instance and subdomain on the mob server
+type TeamMember struct {
+	Name    string      `yaml:"name" json:"name" validate:"required,format=member-name"`
+	Email   string      `yaml:"email" json:"email" validate:"format=email"`
+	SshKeys []string    `yaml:"ssh_keys" json:"ssh_keys"`
+	Git     GitIdentity `yaml:"git" json:"git"`
+}
+
+type GitIdentity struct {
+	Name  string `yaml:"name" json:"name"`
+	Email string `yaml:"email" json:"email" validate:"format=email"`
+}
+
+// HealthInfo configures the checks run after provisioning, see pkg/health
+type HealthInfo struct {
+	FailDeploy bool `yaml:"fail_deploy" json:"fail_deploy"` // fail the deploy when a check fails, otherwise only reported
+}
+
 type SshCredentials struct {
 	Created bool   `yaml:"_" json:"_" `
 	Public  string `yaml:"public" json:"public" `
-	Private string `yaml:"private" json:"private"`
+	Private string `yaml:"private" json:"private" secret:"true"`
 }
 
+// Load reads the stack settings layered over the org-wide defaults file and
+// under the MOB_* environment overrides, fills in defaults and validates the
+// result. Every problem found is reported at once as a ValidationError.
 func (settings *Settings) Load(ctx *pulumi.Context) error {
-	config.New(ctx, "").RequireObject("settings", settings)
-	// Set some defaults and enforce mandatory config settings
-	if settings.HostedZone == "" {
-		return errors.New("hosted_zone must be set")
+	settings.Region = awsRegion(ctx)
+	settings.Project = ctx.Project()
+	settings.Stack = ctx.Stack()
+	stack, err := StackLayer(ctx)
+	if err != nil {
+		return err
 	}
-	if settings.Email == "" {
-		return errors.New("email must be set")
+	env, err := EnvLayer(os.Environ())
+	if err != nil {
+		return err
 	}
-	if settings.MachineInfo.Hostname == "" {
-		return errors.New("instance.hostname must be set")
+	err = settings.LoadLayers(stack, env)
+	for _, warning := range settings.Warnings {
+		ctx.Log.Warn(warning, nil)
 	}
-	if !settings.Gitlab.Enabled && !settings.Github.Enabled {
-		return errors.New("must enable either github or gitlab")
+	// Visible with `pulumi up --debug`
+	var explained strings.Builder
+	settings.Explain(&explained)
+	ctx.Log.Debug("effective settings:\n"+explained.String(), nil)
+	return err
+}
+
+// awsRegion returns the region resources are deployed to, as configured
+// for the aws provider
+func awsRegion(ctx *pulumi.Context) string {
+	if region := config.Get(ctx, "aws:region"); region != "" {
+		return region
 	}
-	if settings.Gitlab.Enabled && settings.Gitlab.Token == "" {
-		return errors.New("must provide a Gitlab token")
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
 	}
-	if settings.Gitlab.Enabled && settings.Gitlab.Username == "" {
-		return errors.New("must provide a Gitlab username")
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// prepare decodes, defaults and validates freshly loaded settings
+func (settings *Settings) prepare() error {
+	var problems ValidationError
+	settings.resolveSecrets(&problems)
+	// Decode the supplied priv key, unless a secret reference gave it as PEM
+	private := settings.MachineInfo.Credentials.Private
+	if private != "" && private != PulumiSecret && !strings.HasPrefix(private, "-----BEGIN") {
+		sDec, e := base64.StdEncoding.DecodeString(private)
+		if e != nil {
+			problems.Add("instance.credentials.private", "must be a base64 encoded private key")
+		} else {
+			settings.MachineInfo.Credentials.Private = string(sDec)
+		}
 	}
-	if settings.Github.Enabled && settings.Github.Token == "" {
-		return errors.New("must provide a Github token")
+	settings.setDefaults()
+	problems = append(problems, settings.validate()...)
+	if len(problems) > 0 {
+		problems.sort()
+		return problems
 	}
-	if settings.Github.Enabled && settings.Github.Username == "" {
-		return errors.New("must provide a Github username")
+	return nil
+}
+
+// FirstMemberPort is the code-server port of the primary user, team
+// members follow on the next ports
+const FirstMemberPort = 8080
+
+// Member is an account on the mob server along with where it is served
+type Member struct {
+	TeamMember
+	Port       int
+	DomainName string
+}
+
+// Members returns the primary user (instance.username) followed by the team
+func (settings *Settings) Members() []Member {
+	members := []Member{{
+		TeamMember: TeamMember{
+			Name:  settings.MachineInfo.UserName,
+			Email: settings.Email,
+			Git:   GitIdentity{Email: settings.Email},
+		},
+		Port:       FirstMemberPort,
+		DomainName: settings.DomainName,
+	}}
+	for i, member := range settings.Team {
+		if member.Git.Name == "" {
+			member.Git.Name = member.Name
+		}
+		if member.Git.Email == "" {
+			member.Git.Email = member.Email
+		}
+		members = append(members, Member{
+			TeamMember: member,
+			Port:       FirstMemberPort + 1 + i,
+			DomainName: fmt.Sprintf("%s.%s", member.Name, settings.DomainName),
+		})
 	}
-	// Decode the supplied priv key
-	if settings.MachineInfo.Credentials.Private != "" {
-		sDec, e := base64.StdEncoding.DecodeString(settings.MachineInfo.Credentials.Private)
-		if e != nil {
-			return e
+	return members
+}
+
+// ResourceTags returns the tags of an AWS resource: the standard Project,
+// Stack, Owner and Expiry tags, overridden by settings.tags, and the
+// resource Name
+func (settings *Settings) ResourceTags(name string) map[string]string {
+	tags := map[string]string{
+		"Project": settings.Project,
+		"Stack":   settings.Stack,
+		"Owner":   settings.Email,
+		"Expiry":  settings.Expires,
+	}
+	for key, value := range settings.Tags {
+		tags[key] = value
+	}
+	tags["Name"] = name
+	for key, value := range tags {
+		if value == "" {
+			delete(tags, key)
 		}
-		settings.MachineInfo.Credentials.Private = string(sDec)
 	}
-	// Set some defaults if not set
-	settings.MachineInfo.OsDist = "ubuntu" // force until we care about something else
+	return tags
+}
+
+// resolveSecrets replaces secret references (eg. env:GITLAB_TOKEN) held by
+// the fields tagged `secret:"true"` with the secrets they point to
+func (settings *Settings) resolveSecrets(problems *ValidationError) {
+	value := reflect.ValueOf(settings).Elem()
+	walkFields(value.Type(), "", func(path string, field reflect.StructField) {
+		if field.Tag.Get("secret") != "true" || field.Type.Kind() != reflect.String {
+			return
+		}
+		fieldValue := value.FieldByIndex(field.Index)
+		secret, err := secrets.Resolve(fieldValue.String())
+		if err != nil {
+			problems.Add(path, "%v", err)
+			return
+		}
+		fieldValue.SetString(secret)
+	})
+}
+
+// Secrets returns the values of the fields tagged `secret:"true"`, for
+// masking them in output
+func (settings *Settings) Secrets() []string {
+	values := []string{}
+	value := reflect.ValueOf(settings).Elem()
+	walkFields(value.Type(), "", func(path string, field reflect.StructField) {
+		if field.Tag.Get("secret") != "true" || field.Type.Kind() != reflect.String {
+			return
+		}
+		if secret := value.FieldByIndex(field.Index).String(); secret != "" && secret != PulumiSecret {
+			values = append(values, secret)
+		}
+	})
+	return values
+}
+
+// SecretMask replaces the secrets in rendered scripts and logs
+const SecretMask = "********"
+
+// MaskSecrets returns a replacer of the Secrets by SecretMask
+func (settings *Settings) MaskSecrets() *strings.Replacer {
+	// longest first, for secrets containing one another
+	secrets := settings.Secrets()
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	pairs := []string{}
+	for _, secret := range secrets {
+		pairs = append(pairs, secret, SecretMask)
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// Distribution returns the distribution of instance.os_dist
+func (settings *Settings) Distribution() (distro.Distribution, error) {
+	return distro.Lookup(settings.MachineInfo.OsDist)
+}
+
+// Value returns the setting at path, eg. github.enabled
+func (settings *Settings) Value(path string) (reflect.Value, bool) {
+	value := reflect.ValueOf(settings).Elem()
+	var found reflect.Value
+	walkFields(value.Type(), "", func(fieldPath string, field reflect.StructField) {
+		if fieldPath == path {
+			found = value.FieldByIndex(field.Index)
+		}
+	})
+	return found, found.IsValid()
+}
+
+// setDefaults sets some defaults if not set
+func (settings *Settings) setDefaults() {
+	if settings.MachineInfo.OsDist == "" {
+		settings.MachineInfo.OsDist = distro.Default
+	}
+	settings.MachineInfo.OsDist = strings.ToLower(settings.MachineInfo.OsDist)
 	switch strings.ToLower(settings.MachineInfo.ResourceType) {
-	default:
-		fallthrough
-	case "spot":
+	case "", "spot":
 		settings.MachineInfo.ResourceType = "spot"
 	case "ec2":
 		settings.MachineInfo.ResourceType = "ec2"
@@ -96,8 +291,13 @@ func (settings *Settings) Load(ctx *pulumi.Context) error {
 	if settings.MachineInfo.UserName == "" {
 		settings.MachineInfo.UserName = "coder"
 	}
+	if settings.MachineInfo.LoginUser == "" {
+		if d, err := distro.Lookup(settings.MachineInfo.OsDist); err == nil {
+			settings.MachineInfo.LoginUser = d.LoginUser()
+		}
+	}
 	if settings.MachineInfo.InstanceType == "" {
-		settings.MachineInfo.UserName = "t3.large"
+		settings.MachineInfo.InstanceType = "t3.large"
 	}
 	if settings.MachineInfo.DiskSizeGB == 0 {
 		settings.MachineInfo.DiskSizeGB = 128
@@ -105,10 +305,8 @@ func (settings *Settings) Load(ctx *pulumi.Context) error {
 	if settings.MachineInfo.OfferSpotPrice == "" {
 		settings.MachineInfo.OfferSpotPrice = "1.00"
 	}
-
 	if settings.ExtraVariables == nil {
 		settings.ExtraVariables = map[string]string{}
 	}
 	settings.DomainName = fmt.Sprintf("%s.%s", settings.MachineInfo.Hostname, settings.HostedZone)
-	return nil
 }
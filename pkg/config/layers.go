@@ -0,0 +1,403 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+	"gopkg.in/yaml.v2"
+)
+
+// Layer is one source of settings, holding the same tree of values as the
+// `mob-server:settings` object. Layers are deep-merged in order: maps are
+// merged key by key, any other value of a later layer replaces the earlier.
+//
+// The precedence, lowest first, is:
+//  1. built-in defaults
+//  2. the org-wide defaults file (settings.defaults_file / MOB_DEFAULTS_FILE)
+//  3. the pulumi stack configuration
+//  4. MOB_* environment variables
+type Layer struct {
+	Name     string
+	Values   map[string]interface{}
+	Warnings []string
+}
+
+const (
+	// EnvPrefix starts the name of every environment variable override
+	EnvPrefix = "MOB_"
+	// PulumiSecret stands in for encrypted values of a configuration file
+	// read outside of a pulumi deployment
+	PulumiSecret = "[pulumi secret]"
+	// BuiltinDefault is reported as the origin of values set by the code
+	BuiltinDefault = "built-in default"
+)
+
+const settingsKey = "mob-server:settings"
+
+// StackLayer returns the settings from the pulumi stack configuration
+func StackLayer(ctx *pulumi.Context) (Layer, error) {
+	layer := Layer{Name: "stack " + ctx.Stack(), Values: map[string]interface{}{}}
+	key := settingsKey
+	if _, ok := ctx.GetConfig(settingsKey); !ok {
+		if _, ok := ctx.GetConfig(legacySettingsKey); ok {
+			key = legacySettingsKey
+			layer.Warnings = append(layer.Warnings, fmt.Sprintf("%s is deprecated, use %s instead",
+				legacySettingsKey, settingsKey))
+		}
+	}
+	if err := config.GetObject(ctx, key, &layer.Values); err != nil {
+		return Layer{}, fmt.Errorf("reading stack settings: %w", err)
+	}
+	return layer, nil
+}
+
+// FileLayer reads a YAML file holding either a bare settings object or a
+// pulumi configuration file with a `mob-server:settings` key. Encrypted
+// pulumi values cannot be read here and are replaced by PulumiSecret.
+func FileLayer(name string, path string) (Layer, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Layer{}, err
+	}
+	doc := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return Layer{}, fmt.Errorf("%s: %w", path, err)
+	}
+	layer := Layer{Name: fmt.Sprintf("%s %s", name, path)}
+	layer.Values, _ = maskPulumiSecrets(normalize(doc)).(map[string]interface{})
+	if cfg, ok := layer.Values["config"].(map[string]interface{}); ok {
+		layer.Values, _ = cfg[settingsKey].(map[string]interface{})
+		if legacy, ok := cfg[legacySettingsKey].(map[string]interface{}); ok && layer.Values == nil {
+			layer.Values = legacy
+			layer.Warnings = append(layer.Warnings, fmt.Sprintf("%s: %s is deprecated, use %s instead",
+				path, legacySettingsKey, settingsKey))
+		}
+	}
+	if layer.Values == nil {
+		layer.Values = map[string]interface{}{}
+	}
+	return layer, nil
+}
+
+// EnvLayer returns the MOB_* overrides found in environ. Every setting has
+// a variable named after its path, eg. MOB_INSTANCE_SPOT_PRICE for
+// instance.spot_price. Lists are comma separated and MOB_VARIABLES_NAME
+// sets the ___NAME___ entry of the variables map.
+func EnvLayer(environ []string) (Layer, error) {
+	env := map[string]string{}
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 && strings.HasPrefix(kv, EnvPrefix) {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	values := map[string]interface{}{}
+	var parseErr error
+	walkFields(reflect.TypeOf(Settings{}), "", func(path string, field reflect.StructField) {
+		name := EnvName(path)
+		if field.Type.Kind() == reflect.Map {
+			for key, value := range env {
+				if !strings.HasPrefix(key, name+"_") {
+					continue
+				}
+				// eg. MOB_TAGS_team, or MOB_VARIABLES_GOLANG_VERSION for ___GOLANG_VERSION___
+				entry := strings.TrimPrefix(key, name+"_")
+				if path == "variables" {
+					entry = "___" + entry + "___"
+				}
+				setPath(values, path+"."+entry, value)
+			}
+			return
+		}
+		raw, ok := env[name]
+		if !ok || field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			return // lists of objects only come from files
+		}
+		value, err := parseEnvValue(field.Type, raw)
+		if err != nil && parseErr == nil {
+			parseErr = fmt.Errorf("%s: %w", name, err)
+		}
+		setPath(values, path, value)
+	})
+	if parseErr != nil {
+		return Layer{}, parseErr
+	}
+	return Layer{Name: "environment", Values: values}, nil
+}
+
+// EnvName returns the environment variable overriding the setting at path
+func EnvName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(path))
+}
+
+func parseEnvValue(typ reflect.Type, raw string) (interface{}, error) {
+	switch typ.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(raw)
+	case reflect.Int:
+		return strconv.Atoi(raw)
+	case reflect.Slice:
+		items := []interface{}{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	default:
+		return raw, nil
+	}
+}
+
+// LoadLayers merges the layers, decodes the result into the settings and
+// then defaults and validates them, recording the origin of every value.
+// The org-wide defaults file named by the layers is read and merged under
+// them.
+func (settings *Settings) LoadLayers(layers ...Layer) error {
+	if path := lookupString(layers, "defaults_file"); path != "" {
+		defaults, err := FileLayer("defaults", path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", settingsPath("defaults_file"), err)
+		}
+		layers = append([]Layer{defaults}, layers...)
+	}
+	merged := map[string]interface{}{}
+	origins := map[string]string{}
+	settings.Warnings = nil
+	for _, layer := range layers {
+		settings.Warnings = append(settings.Warnings, layer.Warnings...)
+		// each layer may still use an older format
+		migrated, err := Migrate(layer.Values)
+		if err != nil {
+			return fmt.Errorf("%s: %w", layer.Name, err)
+		}
+		for _, warning := range migrated {
+			settings.Warnings = append(settings.Warnings, fmt.Sprintf("%s: %s", layer.Name, warning))
+		}
+		mergeValues(merged, layer.Values, "", layer.Name, origins)
+	}
+	b, err := yaml.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(b, settings); err != nil {
+		return fmt.Errorf("decoding settings: %w", err)
+	}
+	settings.Origins = origins
+	settings.Version = CurrentVersion
+	err = settings.prepare()
+	problems, ok := err.(ValidationError)
+	if err != nil && !ok {
+		return err
+	}
+	// enforce the policy on top of the validation
+	if settings.PolicyFile != "" {
+		policy, policyErr := LoadPolicy(settings.PolicyFile)
+		if policyErr != nil {
+			problems.Add("policy_file", "%v", policyErr)
+		} else {
+			settings.Policy = policy
+		}
+	}
+	policyErr := Enforce(settings.Policy.Evaluate(settings), func(message string) {
+		settings.Warnings = append(settings.Warnings, message)
+	})
+	if policyErr != nil {
+		problems = append(problems, policyErr.(ValidationError)...)
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	problems.sort()
+	return problems
+}
+
+// lookupString returns the value at path of the highest layer setting it
+func lookupString(layers []Layer, path string) string {
+	for i := len(layers) - 1; i >= 0; i-- {
+		if value, ok := lookupPath(layers[i].Values, path); ok {
+			if str, ok := value.(string); ok && str != "" {
+				return str
+			}
+		}
+	}
+	return ""
+}
+
+func mergeValues(dst map[string]interface{}, src map[string]interface{}, prefix string,
+	layer string, origins map[string]string) {
+	for key, value := range src {
+		path := joinPath(prefix, key)
+		if value == nil {
+			continue // an empty key does not unset lower layers
+		}
+		if srcMap, ok := value.(map[string]interface{}); ok {
+			dstMap, ok := dst[key].(map[string]interface{})
+			if !ok {
+				dstMap = map[string]interface{}{}
+				dst[key] = dstMap
+			}
+			mergeValues(dstMap, srcMap, path, layer, origins)
+			continue
+		}
+		dst[key] = value
+		origins[path] = layer
+	}
+}
+
+// Origin returns the name of the layer providing the value at path
+func (settings *Settings) Origin(path string) string {
+	if origin, ok := settings.Origins[path]; ok {
+		return origin
+	}
+	return BuiltinDefault
+}
+
+// Explain writes every effective setting along with the layer it came
+// from. Secrets are masked.
+func (settings *Settings) Explain(w io.Writer) {
+	type row struct{ path, value, origin string }
+	rows := []row{}
+	value := reflect.ValueOf(settings).Elem()
+	walkFields(value.Type(), "", func(path string, field reflect.StructField) {
+		fieldValue := value.FieldByIndex(field.Index)
+		if field.Type.Kind() == reflect.Map {
+			keys := fieldValue.MapKeys()
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+			for _, key := range keys {
+				entry := path + "." + key.String()
+				rows = append(rows, row{entry, fmt.Sprint(fieldValue.MapIndex(key)), settings.Origin(entry)})
+			}
+			return
+		}
+		if fieldValue.IsZero() {
+			return
+		}
+		shown := fmt.Sprint(fieldValue.Interface())
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			shown = fmt.Sprintf("%d entries", fieldValue.Len())
+		}
+		if field.Tag.Get("secret") == "true" {
+			shown = "********"
+		}
+		rows = append(rows, row{path, shown, settings.Origin(path)})
+	})
+	width := 0
+	for _, r := range rows {
+		if len(r.path) > width {
+			width = len(r.path)
+		}
+	}
+	for _, r := range rows {
+		fmt.Fprintf(w, "%-*s  %-24s  %s\n", width+len("settings."), settingsPath(r.path), r.value, r.origin)
+	}
+}
+
+// walkFields calls fn for each configurable leaf of a struct type, maps
+// and slices included, with its YAML path. Index is relative to typ.
+func walkFields(typ reflect.Type, prefix string, fn func(path string, field reflect.StructField)) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := yamlName(field)
+		if name == "" {
+			continue
+		}
+		path := joinPath(prefix, name)
+		if field.Type.Kind() == reflect.Struct {
+			walkFields(field.Type, path, func(path string, nested reflect.StructField) {
+				nested.Index = append([]int{i}, nested.Index...)
+				fn(path, nested)
+			})
+			continue
+		}
+		fn(path, field)
+	}
+}
+
+func joinPath(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func lookupPath(values map[string]interface{}, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		value, ok := values[part]
+		if !ok {
+			return nil, false
+		}
+		if i == len(parts)-1 {
+			return value, true
+		}
+		if values, ok = value.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func setPath(values map[string]interface{}, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := values[part].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			values[part] = next
+		}
+		values = next
+	}
+	values[parts[len(parts)-1]] = value
+}
+
+// normalize converts YAML decoded maps into string keyed maps
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[fmt.Sprint(key)] = normalize(item)
+		}
+		return out
+	case yaml.MapSlice:
+		out := make(map[string]interface{}, len(v))
+		for _, item := range v {
+			out[fmt.Sprint(item.Key)] = normalize(item.Value)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = normalize(item)
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+// maskPulumiSecrets replaces the encrypted values of a normalized pulumi
+// configuration file by PulumiSecret
+func maskPulumiSecrets(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if secure, ok := v["secure"].(string); ok && secure != "" && len(v) == 1 {
+			return PulumiSecret
+		}
+		for key, item := range v {
+			v[key] = maskPulumiSecrets(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = maskPulumiSecrets(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnvLayer(t *testing.T) {
+	layer, err := EnvLayer([]string{
+		"HOME=/home/me",
+		"MOB_EMAIL=me@email.com",
+		"MOB_INSTANCE_DISK_SIZE=64",
+		"MOB_GITHUB_ENABLED=true",
+		"MOB_GITHUB_REPOSITORIES=a, b,",
+		"MOB_VARIABLES_GOLANG_VERSION=1.20",
+		"MOB_TAGS_cost-center=rnd",
+		"MOB_NOT_A_SETTING=ignored",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]interface{}{
+		"email": "me@email.com",
+		"instance": map[string]interface{}{
+			"disk_size": 64,
+		},
+		"github": map[string]interface{}{
+			"enabled":      true,
+			"repositories": []interface{}{"a", "b"},
+		},
+		"variables": map[string]interface{}{
+			"___GOLANG_VERSION___": "1.20",
+		},
+		"tags": map[string]interface{}{
+			"cost-center": "rnd",
+		},
+	}
+	if !reflect.DeepEqual(layer.Values, expected) {
+		t.Errorf("expected %v, got %v", expected, layer.Values)
+	}
+
+	if _, err := EnvLayer([]string{"MOB_INSTANCE_DISK_SIZE=big"}); err == nil {
+		t.Errorf("expected an error for a non numeric disk size")
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	defaultsFile := filepath.Join(t.TempDir(), "defaults.yml")
+	err := os.WriteFile(defaultsFile, []byte(`
+config:
+  mob-server:settings:
+    hosted_zone: dev.example.com
+    instance:
+      instance_type: t3a.xlarge
+      disk_size: 256
+    variables:
+      ___GOLANG_VERSION___: 1.19.5
+      ___NVM_VERSION___: 0.39.3
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack := Layer{Name: "stack", Values: map[string]interface{}{
+		"defaults_file": defaultsFile,
+		"email":         "me@email.com",
+		"instance": map[string]interface{}{
+			"hostname":  "cod",
+			"disk_size": 128,
+		},
+		"gitlab": map[string]interface{}{
+			"enabled":      true,
+			"token":        "token",
+			"username":     "me",
+			"repositories": nil,
+		},
+		"variables": map[string]interface{}{
+			"___NVM_VERSION___": "0.40.0",
+		},
+	}}
+	env, err := EnvLayer([]string{"MOB_INSTANCE_HOSTNAME=mob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := Settings{}
+	if err := settings.LoadLayers(stack, env); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if settings.DomainName != "mob.dev.example.com" {
+		t.Errorf("unexpected domain name %q", settings.DomainName)
+	}
+	if settings.MachineInfo.DiskSizeGB != 128 || settings.MachineInfo.InstanceType != "t3a.xlarge" {
+		t.Errorf("instance settings were not merged: %+v", settings.MachineInfo)
+	}
+	expectedVariables := map[string]string{
+		"___GOLANG_VERSION___": "1.19.5",
+		"___NVM_VERSION___":    "0.40.0",
+	}
+	if !reflect.DeepEqual(settings.ExtraVariables, expectedVariables) {
+		t.Errorf("expected variables %v, got %v", expectedVariables, settings.ExtraVariables)
+	}
+	for path, origin := range map[string]string{
+		"hosted_zone":                    "defaults " + defaultsFile,
+		"instance.disk_size":             "stack",
+		"instance.hostname":              "environment",
+		"variables.___GOLANG_VERSION___": "defaults " + defaultsFile,
+		"variables.___NVM_VERSION___":    "stack",
+		"instance.username":              BuiltinDefault,
+	} {
+		if settings.Origin(path) != origin {
+			t.Errorf("expected %s to come from %q, got %q", path, origin, settings.Origin(path))
+		}
+	}
+}
+
+func TestLoadLayersWithPolicy(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.yml")
+	if err := os.WriteFile(policyFile, []byte("max_disk_size: 512\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stack := Layer{Name: "stack", Values: map[string]interface{}{
+		"policy_file": policyFile,
+		"email":       "me@email.com",
+		"hosted_zone": "dev.example.com",
+		"instance": map[string]interface{}{
+			"hostname":  "cod",
+			"disk_size": 4,
+		},
+	}}
+	settings := Settings{}
+	err := settings.LoadLayers(stack)
+	if err == nil || !strings.Contains(err.Error(), "instance.disk_size") {
+		t.Fatalf("expected the validation error of instance.disk_size, got %v", err)
+	}
+
+	// a policy file that can't be read is one more problem
+	if err := os.WriteFile(policyFile, []byte("max_disk_size: [\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	settings = Settings{}
+	err = settings.LoadLayers(stack)
+	if _, ok := err.(ValidationError); !ok || !strings.Contains(err.Error(), "policy_file") || !strings.Contains(err.Error(), "instance.disk_size") {
+		t.Errorf("expected the problems of policy_file and instance.disk_size, got %v", err)
+	}
+}
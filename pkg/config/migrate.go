@@ -0,0 +1,168 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// CurrentVersion is the version of the settings format read by this program
+const CurrentVersion = 1
+
+// legacySettingsKey is where the settings lived before the project rename
+const legacySettingsKey = "code-server:settings"
+
+// Migration upgrades a settings tree from version From to From+1, returning
+// a warning for every deprecated key it had to rewrite
+type Migration struct {
+	From        int
+	Description string
+	Apply       func(values map[string]interface{}) []string
+}
+
+var migrations = []Migration{
+	{
+		From:        0,
+		Description: "move instance.developer and instance.vpc_id to instance.username and vpc_id",
+		Apply: func(values map[string]interface{}) []string {
+			return append(
+				moveKey(values, "instance.developer", "instance.username"),
+				moveKey(values, "instance.vpc_id", "vpc_id")...,
+			)
+		},
+	},
+}
+
+// Migrate upgrades a settings tree in place to CurrentVersion, leaving the
+// version key itself untouched. A missing version is taken as 0, the
+// unversioned format.
+func Migrate(values map[string]interface{}) ([]string, error) {
+	version := 0
+	if raw, ok := values["version"]; ok {
+		switch v := raw.(type) {
+		case int:
+			version = v
+		case float64: // decoded from JSON
+			version = int(v)
+		default:
+			return nil, fmt.Errorf("%s must be a number, got %v", settingsPath("version"), raw)
+		}
+	}
+	if version > CurrentVersion {
+		return nil, fmt.Errorf("%s is %d, this program only reads up to version %d",
+			settingsPath("version"), version, CurrentVersion)
+	}
+	warnings := []string{}
+	for _, migration := range migrations {
+		if migration.From < version {
+			continue
+		}
+		warnings = append(warnings, migration.Apply(values)...)
+		version = migration.From + 1
+	}
+	return warnings, nil
+}
+
+// moveKey moves a deprecated key, unless the new key is already set
+func moveKey(values map[string]interface{}, from string, to string) []string {
+	value, ok := lookupPath(values, from)
+	if !ok {
+		return nil
+	}
+	deletePath(values, from)
+	if _, exists := lookupPath(values, to); exists {
+		return []string{fmt.Sprintf("%s is deprecated and ignored since %s is set",
+			settingsPath(from), settingsPath(to))}
+	}
+	setPath(values, to, value)
+	return []string{fmt.Sprintf("%s is deprecated, use %s instead", settingsPath(from), settingsPath(to))}
+}
+
+func deletePath(values map[string]interface{}, path string) {
+	parent, name := values, path
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		value, ok := lookupPath(values, path[:i])
+		if parent, ok = value.(map[string]interface{}); !ok {
+			return
+		}
+		name = path[i+1:]
+	}
+	delete(parent, name)
+}
+
+// MigrateFile upgrades the settings of a pulumi configuration file to
+// CurrentVersion. When write is set and anything changed, the file is
+// rewritten in place after saving a copy with a .bak suffix. Keys outside
+// of the settings keep their order, the settings are written sorted.
+func MigrateFile(path string, write bool) (warnings []string, changed bool, err error) {
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	doc := yaml.MapSlice{}
+	if err := yaml.Unmarshal(original, &doc); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", path, err)
+	}
+	cfg, ok := mapSliceValue(doc, "config").(yaml.MapSlice)
+	if !ok {
+		return nil, false, fmt.Errorf("%s: no config section", path)
+	}
+	var values map[string]interface{}
+	for i, item := range cfg {
+		if item.Key != settingsKey && item.Key != legacySettingsKey {
+			continue
+		}
+		if item.Key == legacySettingsKey {
+			warnings = append(warnings, fmt.Sprintf("%s is deprecated, use %s instead", legacySettingsKey, settingsKey))
+			cfg[i].Key = settingsKey
+		}
+		values, _ = normalize(item.Value).(map[string]interface{})
+		if values == nil {
+			return warnings, false, fmt.Errorf("%s: %s is not an object", path, settingsKey)
+		}
+		changed = changed || item.Key == legacySettingsKey || values["version"] != CurrentVersion
+		migrated, err := Migrate(values)
+		if err != nil {
+			return nil, false, err
+		}
+		warnings = append(warnings, migrated...)
+		values["version"] = CurrentVersion
+		cfg[i].Value = values
+	}
+	if values == nil {
+		return nil, false, fmt.Errorf("%s: no %s section", path, settingsKey)
+	}
+	rewritten, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, false, err
+	}
+	// keep the header comments, like the schema reference
+	header := bytes.Buffer{}
+	for _, line := range bytes.SplitAfter(original, []byte("\n")) {
+		if !bytes.HasPrefix(line, []byte("#")) {
+			break
+		}
+		header.Write(line)
+	}
+	rewritten = append(header.Bytes(), rewritten...)
+	if !write || !changed {
+		return warnings, changed, nil
+	}
+	if err := os.WriteFile(path+".bak", original, 0600); err != nil {
+		return nil, false, err
+	}
+	return warnings, changed, os.WriteFile(path, rewritten, 0600)
+}
+
+func mapSliceValue(items yaml.MapSlice, key string) interface{} {
+	for _, item := range items {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	testCases := []struct {
+		name             string
+		input            map[string]interface{}
+		expected         map[string]interface{}
+		expectedWarnings int
+		expectedErr      bool
+	}{
+		{
+			name: "unversioned settings with deprecated keys",
+			input: map[string]interface{}{
+				"instance": map[string]interface{}{
+					"developer": "gunner",
+					"vpc_id":    "vpc-0123456789abcdef0",
+				},
+			},
+			expected: map[string]interface{}{
+				"instance": map[string]interface{}{
+					"username": "gunner",
+				},
+				"vpc_id": "vpc-0123456789abcdef0",
+			},
+			expectedWarnings: 2,
+		},
+		{
+			name: "deprecated key does not replace the current one",
+			input: map[string]interface{}{
+				"vpc_id":   "vpc-0123456789abcdef0",
+				"instance": map[string]interface{}{"vpc_id": "vpc-ffffffffffffffff0"},
+			},
+			expected: map[string]interface{}{
+				"vpc_id":   "vpc-0123456789abcdef0",
+				"instance": map[string]interface{}{},
+			},
+			expectedWarnings: 1,
+		},
+		{
+			name: "current settings are left alone",
+			input: map[string]interface{}{
+				"version":  float64(1),
+				"instance": map[string]interface{}{"developer": "gunner"},
+			},
+			expected: map[string]interface{}{
+				"version":  float64(1),
+				"instance": map[string]interface{}{"developer": "gunner"},
+			},
+		},
+		{
+			name:        "newer settings are refused",
+			input:       map[string]interface{}{"version": CurrentVersion + 1},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			warnings, err := Migrate(tc.input)
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(warnings) != tc.expectedWarnings {
+				t.Errorf("expected %d warnings, got %v", tc.expectedWarnings, warnings)
+			}
+			if !reflect.DeepEqual(tc.input, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, tc.input)
+			}
+		})
+	}
+}
+
+func TestMigrateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "configuration.yml")
+	original := `# header comment
+config:
+  aws:region: us-west-2
+  code-server:settings:
+    email: me@email.com
+    gitlab:
+      token:
+        secure: AAABAKX
+    instance:
+      developer: gunner
+`
+	if err := os.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	warnings, changed, err := MigrateFile(path, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !changed || len(warnings) != 2 {
+		t.Fatalf("expected a change with 2 warnings, got %v %v", changed, warnings)
+	}
+	if b, _ := os.ReadFile(path); string(b) != original {
+		t.Fatalf("file rewritten without write")
+	}
+
+	if _, _, err := MigrateFile(path, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, _ := os.ReadFile(path)
+	rewritten := string(b)
+	for _, expected := range []string{
+		"# header comment\n",
+		"  mob-server:settings:\n",
+		"      username: gunner\n",
+		"        secure: AAABAKX\n",
+		"    version: 1\n",
+	} {
+		if !strings.Contains(rewritten, expected) {
+			t.Errorf("expected %q in:\n%s", expected, rewritten)
+		}
+	}
+	if b, _ := os.ReadFile(path + ".bak"); string(b) != original {
+		t.Errorf("expected a backup of the original file")
+	}
+
+	_, changed, err = MigrateFile(path, true)
+	if err != nil || changed {
+		t.Errorf("expected migrated file to be current, got %v %v", changed, err)
+	}
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Policy holds the guardrails a team lead puts on deployments. It is read
+// from the YAML file named by settings.policy_file, typically set in the
+// org-wide defaults file. Rules left empty are not enforced.
+type Policy struct {
+	AllowedInstanceTypes []string `yaml:"allowed_instance_types"` // glob patterns, eg. t3a.*
+	MaxDiskSize          int      `yaml:"max_disk_size"`          // GB
+	MaxSpotPrice         string   `yaml:"max_spot_price"`         // USD per hour
+	RequiredTags         []string `yaml:"required_tags"`
+	AllowedRegions       []string `yaml:"allowed_regions"`
+	WarnOnly             []string `yaml:"warn_only"` // rules reported as warnings instead of failing the deploy
+}
+
+// Policy rule names, as used in warn_only
+const (
+	RuleAllowedInstanceTypes = "allowed_instance_types"
+	RuleMaxDiskSize          = "max_disk_size"
+	RuleMaxSpotPrice         = "max_spot_price"
+	RuleRequiredTags         = "required_tags"
+	RuleAllowedRegions       = "allowed_regions"
+)
+
+var policyRules = []string{
+	RuleAllowedInstanceTypes,
+	RuleMaxDiskSize,
+	RuleMaxSpotPrice,
+	RuleRequiredTags,
+	RuleAllowedRegions,
+}
+
+// regionPath is the pulumi configuration key of the AWS region
+const regionPath = "aws:region"
+
+// Violation is a setting breaking a policy rule
+type Violation struct {
+	Rule    string
+	Path    string // full path of the setting, eg. settings.instance.disk_size
+	Message string
+	Warn    bool // only warn, the deploy goes on
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s violates the %s policy: %s", v.Path, v.Rule, v.Message)
+}
+
+// LoadPolicy reads a policy file
+func LoadPolicy(file string) (*Policy, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	policy := &Policy{}
+	if err := yaml.UnmarshalStrict(b, policy); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for _, rule := range policy.WarnOnly {
+		if !contains(policyRules, rule) {
+			return nil, fmt.Errorf("%s: unknown rule %q in warn_only, expected one of %s",
+				file, rule, strings.Join(policyRules, ", "))
+		}
+	}
+	if policy.MaxSpotPrice != "" {
+		if _, err := strconv.ParseFloat(policy.MaxSpotPrice, 64); err != nil {
+			return nil, fmt.Errorf("%s: max_spot_price must be a number, got %q", file, policy.MaxSpotPrice)
+		}
+	}
+	return policy, nil
+}
+
+// Evaluate checks the settings against every rule of the policy
+func (policy *Policy) Evaluate(settings *Settings) []Violation {
+	if policy == nil {
+		return nil
+	}
+	violations := policy.CheckInstanceType(settings.MachineInfo.InstanceType)
+	if policy.MaxDiskSize > 0 && settings.MachineInfo.DiskSizeGB > policy.MaxDiskSize {
+		violations = append(violations, policy.violation(RuleMaxDiskSize, "instance.disk_size",
+			"%d GB is over the maximum of %d GB", settings.MachineInfo.DiskSizeGB, policy.MaxDiskSize))
+	}
+	if settings.MachineInfo.ResourceType == "spot" {
+		violations = append(violations, policy.checkSpotPrice("instance.spot_price", "offer",
+			settings.MachineInfo.OfferSpotPrice)...)
+	}
+	// the tags applied to the resources, the standard ones included
+	tags := settings.ResourceTags(settings.DomainName)
+	for _, tag := range policy.RequiredTags {
+		if tags[tag] == "" {
+			violations = append(violations, policy.violation(RuleRequiredTags, "tags."+tag, "must be set"))
+		}
+	}
+	if len(policy.AllowedRegions) > 0 && settings.Region != "" && !contains(policy.AllowedRegions, settings.Region) {
+		violations = append(violations, policy.violation(RuleAllowedRegions, regionPath,
+			"%s is not one of the allowed regions %s", settings.Region, strings.Join(policy.AllowedRegions, ", ")))
+	}
+	return violations
+}
+
+// CheckInstanceType checks an instance type against the allowed patterns
+func (policy *Policy) CheckInstanceType(instanceType string) []Violation {
+	if policy == nil || len(policy.AllowedInstanceTypes) == 0 {
+		return nil
+	}
+	for _, pattern := range policy.AllowedInstanceTypes {
+		if ok, _ := path.Match(pattern, instanceType); ok {
+			return nil
+		}
+	}
+	return []Violation{policy.violation(RuleAllowedInstanceTypes, "instance.instance_type",
+		"%s is not one of the allowed instance types %s", instanceType, strings.Join(policy.AllowedInstanceTypes, ", "))}
+}
+
+// CheckSpotMarketPrice checks the current market price of the instance type
+func (policy *Policy) CheckSpotMarketPrice(price string) []Violation {
+	if policy == nil {
+		return nil
+	}
+	return policy.checkSpotPrice("instance.instance_type", "market price", price)
+}
+
+func (policy *Policy) checkSpotPrice(path string, what string, price string) []Violation {
+	if policy.MaxSpotPrice == "" {
+		return nil
+	}
+	maximum, _ := strconv.ParseFloat(policy.MaxSpotPrice, 64)
+	value, err := strconv.ParseFloat(price, 64)
+	if err != nil || value <= maximum {
+		return nil
+	}
+	return []Violation{policy.violation(RuleMaxSpotPrice, path,
+		"spot %s of %s/hr USD is over the maximum of %s/hr USD", what, price, policy.MaxSpotPrice)}
+}
+
+func (policy *Policy) violation(rule string, path string, format string, args ...interface{}) Violation {
+	if path != regionPath {
+		path = settingsPath(path)
+	}
+	return Violation{
+		Rule:    rule,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+		Warn:    contains(policy.WarnOnly, rule),
+	}
+}
+
+// Enforce reports the violations only warning through warn, and returns
+// the others as a ValidationError
+func Enforce(violations []Violation, warn func(message string)) error {
+	var problems ValidationError
+	for _, violation := range violations {
+		if violation.Warn {
+			warn(violation.String())
+			continue
+		}
+		problems = append(problems, FieldError{
+			Path:    violation.Path,
+			Message: fmt.Sprintf("violates the %s policy: %s", violation.Rule, violation.Message),
+		})
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	policy := &Policy{
+		AllowedInstanceTypes: []string{"t3.*", "t3a.*"},
+		MaxDiskSize:          256,
+		MaxSpotPrice:         "0.20",
+		RequiredTags:         []string{"team", "Owner"},
+		AllowedRegions:       []string{"us-west-2"},
+		WarnOnly:             []string{RuleMaxSpotPrice},
+	}
+	testCases := []struct {
+		name           string
+		modify         func(settings *Settings)
+		expectedPaths  []string
+		expectedWarned []string
+	}{
+		{
+			name:   "compliant settings",
+			modify: func(settings *Settings) {},
+		},
+		{
+			name: "denied violations",
+			modify: func(settings *Settings) {
+				settings.MachineInfo.InstanceType = "m5.24xlarge"
+				settings.MachineInfo.DiskSizeGB = 1024
+				settings.Tags = nil
+				settings.Region = "eu-west-1"
+			},
+			expectedPaths: []string{
+				"aws:region",
+				"settings.instance.disk_size",
+				"settings.instance.instance_type",
+				"settings.tags.team",
+			},
+		},
+		{
+			name: "missing standard tag",
+			modify: func(settings *Settings) {
+				settings.Email = ""
+			},
+			expectedPaths: []string{"settings.tags.Owner"},
+		},
+		{
+			name: "warn only violations",
+			modify: func(settings *Settings) {
+				settings.MachineInfo.OfferSpotPrice = "1.00"
+			},
+			expectedWarned: []string{"settings.instance.spot_price"},
+		},
+		{
+			name: "spot price is not checked for on demand instances",
+			modify: func(settings *Settings) {
+				settings.MachineInfo.ResourceType = "ec2"
+				settings.MachineInfo.OfferSpotPrice = "1.00"
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			settings := validSettings()
+			settings.MachineInfo.ResourceType = "spot"
+			settings.MachineInfo.OfferSpotPrice = "0.10"
+			settings.Tags = map[string]string{"team": "platform"}
+			settings.Region = "us-west-2"
+			tc.modify(&settings)
+
+			var warned []string
+			for _, violation := range policy.Evaluate(&settings) {
+				if violation.Warn {
+					warned = append(warned, violation.Path)
+				}
+			}
+			if !reflect.DeepEqual(warned, tc.expectedWarned) {
+				t.Errorf("expected warnings at %v, got %v", tc.expectedWarned, warned)
+			}
+
+			err := Enforce(policy.Evaluate(&settings), func(string) {})
+			if tc.expectedPaths == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var problems ValidationError
+			if !errors.As(err, &problems) {
+				t.Fatalf("expected a ValidationError, got %v", err)
+			}
+			problems.sort()
+			paths := []string{}
+			for _, problem := range problems {
+				paths = append(paths, problem.Path)
+			}
+			if !reflect.DeepEqual(paths, tc.expectedPaths) {
+				t.Errorf("expected problems at %v, got:\n%v", tc.expectedPaths, err)
+			}
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	testCases := []struct {
+		name        string
+		content     string
+		expectedErr bool
+	}{
+		{
+			name:    "valid policy",
+			content: "allowed_instance_types: [t3a.*]\nmax_spot_price: \"0.20\"\nwarn_only: [max_disk_size]\n",
+		},
+		{
+			name:        "unknown rule",
+			content:     "max_memory: 64\n",
+			expectedErr: true,
+		},
+		{
+			name:        "unknown warn only rule",
+			content:     "warn_only: [max_memory]\n",
+			expectedErr: true,
+		},
+		{
+			name:        "malformed price",
+			content:     "max_spot_price: cheap\n",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(dir, filepath.Base(t.Name())+".yml")
+			if err := os.WriteFile(file, []byte(tc.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadPolicy(file)
+			if (err != nil) != tc.expectedErr {
+				t.Errorf("expected error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
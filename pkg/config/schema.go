@@ -0,0 +1,115 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/slim-ai/mob-code-server/pkg/secrets"
+)
+
+// Schema returns a JSON Schema (draft-07) describing a pulumi configuration
+// file such as config/configuration.yml. The `mob-server:settings` object is
+// generated from the yaml, validate and secret tags of the Settings struct.
+func Schema() ([]byte, error) {
+	doc := map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"title":   "mob-server configuration",
+		"type":    "object",
+		"properties": map[string]interface{}{
+			"config": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"mob-server:settings": map[string]interface{}{"$ref": "#/definitions/settings"},
+				},
+			},
+		},
+		"definitions": map[string]interface{}{
+			"settings": structSchema(reflect.TypeOf(Settings{})),
+			// values set with `pulumi config set --secret`
+			"secure": map[string]interface{}{
+				"type":                 "object",
+				"required":             []string{"secure"},
+				"additionalProperties": false,
+				"properties": map[string]interface{}{
+					"secure": map[string]interface{}{"type": "string"},
+				},
+			},
+		},
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func structSchema(typ reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := yamlName(field)
+		if name == "" {
+			continue
+		}
+		rules := parseFieldRules(field.Tag.Get("validate"))
+		if rules.required {
+			required = append(required, name)
+		}
+		schema := typeSchema(field.Type, rules)
+		if field.Tag.Get("secret") == "true" {
+			schema = map[string]interface{}{
+				"description": "the secret itself, or a reference to it using one of the schemes: " +
+					strings.Join(secrets.Schemes(), ", ") + " (eg. env:GITLAB_TOKEN)",
+				"anyOf": []interface{}{schema, map[string]interface{}{"$ref": "#/definitions/secure"}},
+			}
+		}
+		properties[name] = schema
+	}
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func typeSchema(typ reflect.Type, rules fieldRules) map[string]interface{} {
+	switch typ.Kind() {
+	case reflect.Struct:
+		return structSchema(typ)
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  []string{"array", "null"},
+			"items": typeSchema(typ.Elem(), fieldRules{}),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 []string{"object", "null"},
+			"additionalProperties": typeSchema(typ.Elem(), fieldRules{}),
+		}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int:
+		schema := map[string]interface{}{"type": "integer"}
+		if rules.min != nil {
+			schema["minimum"] = *rules.min
+		}
+		if rules.max != nil {
+			schema["maximum"] = *rules.max
+		}
+		return schema
+	default:
+		schema := map[string]interface{}{"type": "string"}
+		if len(rules.enum) > 0 {
+			schema["enum"] = rules.enum
+		}
+		if rules.format != "" {
+			schema["pattern"] = formats[rules.format].pattern.String()
+		}
+		if rules.format == "email" {
+			schema["format"] = "email"
+		}
+		return schema
+	}
+}
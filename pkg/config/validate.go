@@ -0,0 +1,310 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slim-ai/mob-code-server/pkg/components"
+	"github.com/slim-ai/mob-code-server/pkg/distro"
+)
+
+// FieldError describes a single invalid setting, identified by its YAML path
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationError lists every invalid setting found during a validation pass
+type ValidationError []FieldError
+
+// Add records a problem for the field at path, relative to the settings root
+func (e *ValidationError) Add(path string, format string, args ...interface{}) {
+	*e = append(*e, FieldError{
+		Path:    settingsPath(path),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (e ValidationError) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, fmt.Sprintf("invalid configuration (%d problems):", len(e)))
+	for _, problem := range e {
+		lines = append(lines, "  - "+problem.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// settingsPath prefixes a field path with the name of the stack config object
+func settingsPath(path string) string {
+	if path == "" {
+		return "settings"
+	}
+	return "settings." + path
+}
+
+// fieldFormat is a named check usable from a `validate:"format=..."` tag
+type fieldFormat struct {
+	pattern *regexp.Regexp
+	check   func(value string) bool // optional extra check, after the pattern
+	message string
+}
+
+var formats = map[string]fieldFormat{
+	"dns-label": {
+		pattern: regexp.MustCompile(`^[a-zA-Z0-9]([-a-zA-Z0-9]{0,61}[a-zA-Z0-9])?$`),
+		message: "must be a valid DNS label (letters, digits and '-', at most 63 characters)",
+	},
+	"dns-name": {
+		pattern: regexp.MustCompile(`^([a-zA-Z0-9]([-a-zA-Z0-9]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]{2,63}\.?$`),
+		message: "must be a fully qualified domain name (eg. dev.example.com)",
+	},
+	"email": {
+		pattern: regexp.MustCompile(`^[^@\s]+@[^@\s]+$`),
+		check: func(value string) bool {
+			addr, err := mail.ParseAddress(value)
+			return err == nil && addr.Address == value
+		},
+		message: "must be a valid email address",
+	},
+	"vpc-id": {
+		pattern: regexp.MustCompile(`^vpc-[0-9a-f]{8,17}$`),
+		message: "must be a VPC id (eg. vpc-0123456789abcdef0)",
+	},
+	"member-name": {
+		// used both as a linux user and a DNS label
+		pattern: regexp.MustCompile(`^[a-z][a-z0-9-]{0,31}$`),
+		message: "must be a lower case linux user name and DNS label (letters, digits and '-')",
+	},
+	"unix-user": {
+		pattern: regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`),
+		message: "must be a valid linux user name (lower case, at most 32 characters)",
+	},
+	"instance-type": {
+		pattern: regexp.MustCompile(`^[a-z][a-z0-9-]*\.[a-z0-9]+$`),
+		message: "must be an EC2 instance type (eg. t3a.xlarge)",
+	},
+	"price": {
+		pattern: regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`),
+		check: func(value string) bool {
+			price, err := strconv.ParseFloat(value, 64)
+			return err == nil && price > 0
+		},
+		message: "must be a positive number of USD per hour (eg. 1.00)",
+	},
+	"date": {
+		pattern: regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`),
+		check: func(value string) bool {
+			_, err := time.Parse("2006-01-02", value)
+			return err == nil
+		},
+		message: "must be a date (eg. 2024-12-31)",
+	},
+}
+
+// fieldRules holds the parsed content of a `validate` struct tag
+type fieldRules struct {
+	required bool
+	format   string
+	enum     []string
+	min      *int
+	max      *int
+}
+
+func parseFieldRules(tag string) fieldRules {
+	rules := fieldRules{}
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+		switch name {
+		case "required":
+			rules.required = true
+		case "format":
+			if _, ok := formats[arg]; !ok {
+				panic(fmt.Sprintf("unknown validation format %q", arg))
+			}
+			rules.format = arg
+		case "enum":
+			rules.enum = strings.Split(arg, "|")
+		case "min", "max":
+			value, err := strconv.Atoi(arg)
+			if err != nil {
+				panic(fmt.Sprintf("invalid %s bound %q", name, arg))
+			}
+			if name == "min" {
+				rules.min = &value
+			} else {
+				rules.max = &value
+			}
+		case "":
+		default:
+			panic(fmt.Sprintf("unknown validation rule %q", name))
+		}
+	}
+	return rules
+}
+
+// yamlName returns the YAML key of a struct field, or "" when the
+// field is not read from configuration
+func yamlName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "-" || name == "_" {
+		return ""
+	}
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// Validate checks the settings against the rules declared in the struct
+// tags along with the rules spanning several fields. All problems are
+// returned together, or nil when the settings are valid.
+func (settings *Settings) Validate() error {
+	if problems := settings.validate(); len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func (settings *Settings) validate() ValidationError {
+	var problems ValidationError
+	validateStruct(reflect.ValueOf(settings).Elem(), "", &problems)
+
+	if !settings.Gitlab.Enabled && !settings.Github.Enabled {
+		problems.Add("", "must enable either github or gitlab")
+	}
+	for path, cvs := range map[string]ConcurrentVersionsSystemInfo{
+		"gitlab": settings.Gitlab,
+		"github": settings.Github,
+	} {
+		if !cvs.Enabled {
+			continue
+		}
+		if cvs.Token == "" {
+			problems.Add(path+".token", "must be set when %s is enabled", path)
+		}
+		if cvs.Username == "" {
+			problems.Add(path+".username", "must be set when %s is enabled", path)
+		}
+	}
+	names := map[string]bool{settings.MachineInfo.UserName: true}
+	for i, member := range settings.Team {
+		path := fmt.Sprintf("team[%d]", i)
+		if names[member.Name] {
+			problems.Add(path+".name", "%q is already used by another account", member.Name)
+		}
+		names[member.Name] = true
+		for j, key := range member.SshKeys {
+			if len(strings.Fields(key)) < 2 {
+				problems.Add(fmt.Sprintf("%s.ssh_keys[%d]", path, j), "must be a public key (eg. ssh-ed25519 AAAA...)")
+			}
+		}
+	}
+	for key, value := range settings.Tags {
+		path := "tags." + key
+		switch {
+		case key == "" || len(key) > 128:
+			problems.Add(path, "tag keys must be 1 to 128 characters long")
+		case strings.HasPrefix(strings.ToLower(key), "aws:"):
+			problems.Add(path, "tag keys starting with aws: are reserved by AWS")
+		case key == "Name":
+			problems.Add(path, "the Name tag is set to the resource name")
+		case len(value) > 256:
+			problems.Add(path, "tag values must be at most 256 characters long")
+		}
+	}
+	if d, err := settings.Distribution(); err != nil {
+		problems.Add("instance.os_dist", "%s", err)
+	} else if architecture := distro.Architecture(settings.MachineInfo.InstanceType); !distro.Supports(d, architecture) {
+		problems.Add("instance.instance_type", "%s is an %s instance type, %s only runs on %s",
+			settings.MachineInfo.InstanceType, architecture, d.Name(), strings.Join(d.Architectures(), ", "))
+	}
+	for i, request := range settings.Components {
+		if _, _, err := components.Parse(request); err != nil {
+			problems.Add(fmt.Sprintf("components[%d]", i), "%s", err)
+		}
+	}
+	problems.sort()
+	return problems
+}
+
+func validateStruct(value reflect.Value, prefix string, problems *ValidationError) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name := yamlName(field)
+		if name == "" {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		validateField(value.Field(i), path, parseFieldRules(field.Tag.Get("validate")), problems)
+	}
+}
+
+func validateField(value reflect.Value, path string, rules fieldRules, problems *ValidationError) {
+	if value.IsZero() {
+		if rules.required {
+			problems.Add(path, "must be set")
+		}
+		if value.Kind() != reflect.Struct {
+			return
+		}
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		validateStruct(value, path, problems)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Struct {
+			for i := 0; i < value.Len(); i++ {
+				validateStruct(value.Index(i), fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	case reflect.String:
+		str := value.String()
+		if len(rules.enum) > 0 && !contains(rules.enum, str) {
+			problems.Add(path, "must be one of %s, got %q", strings.Join(rules.enum, ", "), str)
+		}
+		if rules.format != "" {
+			format := formats[rules.format]
+			if !format.pattern.MatchString(str) || (format.check != nil && !format.check(str)) {
+				problems.Add(path, "%s, got %q", format.message, str)
+			}
+		}
+	case reflect.Int:
+		number := int(value.Int())
+		if rules.min != nil && number < *rules.min {
+			problems.Add(path, "must be at least %d, got %d", *rules.min, number)
+		}
+		if rules.max != nil && number > *rules.max {
+			problems.Add(path, "must be at most %d, got %d", *rules.max, number)
+		}
+	}
+}
+
+// sort orders problems by path, keeping the check order for a shared path
+func (e ValidationError) sort() {
+	sort.SliceStable(e, func(i, j int) bool { return e[i].Path < e[j].Path })
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func validSettings() Settings {
+	return Settings{
+		Email:      "me@email.com",
+		HostedZone: "dev.example.com",
+		MachineInfo: MachineInfo{
+			Hostname:     "cod",
+			InstanceType: "t3a.xlarge",
+			DiskSizeGB:   128,
+		},
+		Gitlab: ConcurrentVersionsSystemInfo{
+			Enabled:  true,
+			Token:    "mygitlabtoken",
+			Username: "mygitlabuser",
+		},
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	testCases := []struct {
+		name          string
+		modify        func(settings *Settings)
+		expectedPaths []string
+	}{
+		{
+			name:          "valid settings",
+			modify:        func(settings *Settings) {},
+			expectedPaths: nil,
+		},
+		{
+			name: "missing mandatory settings are all reported",
+			modify: func(settings *Settings) {
+				settings.Email = ""
+				settings.HostedZone = ""
+				settings.MachineInfo.Hostname = ""
+			},
+			expectedPaths: []string{
+				"settings.email",
+				"settings.hosted_zone",
+				"settings.instance.hostname",
+			},
+		},
+		{
+			name: "malformed values",
+			modify: func(settings *Settings) {
+				settings.Email = "not an email"
+				settings.MachineInfo.Hostname = "-cod-"
+				settings.MachineInfo.OfferSpotPrice = "cheap"
+				settings.MachineInfo.DiskSizeGB = 100000
+				settings.MachineInfo.ResourceType = "lambda"
+				settings.VpcId = "my-vpc"
+			},
+			expectedPaths: []string{
+				"settings.email",
+				"settings.instance.disk_size",
+				"settings.instance.hostname",
+				"settings.instance.resource_type",
+				"settings.instance.spot_price",
+				"settings.vpc_id",
+			},
+		},
+		{
+			name: "zero spot price",
+			modify: func(settings *Settings) {
+				settings.MachineInfo.OfferSpotPrice = "0.00"
+			},
+			expectedPaths: []string{"settings.instance.spot_price"},
+		},
+		{
+			name: "no version control system enabled",
+			modify: func(settings *Settings) {
+				settings.Gitlab.Enabled = false
+			},
+			expectedPaths: []string{"settings"},
+		},
+		{
+			name: "enabled version control systems need credentials",
+			modify: func(settings *Settings) {
+				settings.Gitlab.Token = ""
+				settings.Github.Enabled = true
+			},
+			expectedPaths: []string{
+				"settings.github.token",
+				"settings.github.username",
+				"settings.gitlab.token",
+			},
+		},
+		{
+			name: "private key must be base64 encoded",
+			modify: func(settings *Settings) {
+				settings.MachineInfo.Credentials.Private = "not base64!"
+			},
+			expectedPaths: []string{"settings.instance.credentials.private"},
+		},
+		{
+			name: "unresolvable secret reference",
+			modify: func(settings *Settings) {
+				settings.Gitlab.Token = "env:MOB_TEST_UNSET_GITLAB_TOKEN"
+			},
+			expectedPaths: []string{"settings.gitlab.token"},
+		},
+		{
+			name: "invalid tags and expiry date",
+			modify: func(settings *Settings) {
+				settings.Tags = map[string]string{
+					"team":      "platform",
+					"aws:owner": "me",
+					"Name":      "mine",
+				}
+				settings.Expires = "2024-13-01"
+			},
+			expectedPaths: []string{
+				"settings.expires",
+				"settings.tags.Name",
+				"settings.tags.aws:owner",
+			},
+		},
+		{
+			name: "unknown component",
+			modify: func(settings *Settings) {
+				settings.Components = []string{"go@1.20.4", "rust", "pulumi@3.0.0"}
+			},
+			expectedPaths: []string{"settings.components[1]", "settings.components[2]"},
+		},
+		{
+			name: "unknown distribution",
+			modify: func(settings *Settings) {
+				settings.MachineInfo.OsDist = "arch"
+			},
+			expectedPaths: []string{"settings.instance.os_dist"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			settings := validSettings()
+			tc.modify(&settings)
+			err := settings.prepare()
+			if tc.expectedPaths == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var problems ValidationError
+			if !errors.As(err, &problems) {
+				t.Fatalf("expected a ValidationError, got %v", err)
+			}
+			paths := []string{}
+			for _, problem := range problems {
+				paths = append(paths, problem.Path)
+			}
+			if !reflect.DeepEqual(paths, tc.expectedPaths) {
+				t.Errorf("expected problems at %v, got:\n%v", tc.expectedPaths, err)
+			}
+		})
+	}
+}
+
+func TestPrepareDefaults(t *testing.T) {
+	settings := validSettings()
+	settings.MachineInfo.InstanceType = ""
+	settings.MachineInfo.ResourceType = "EC2"
+	settings.MachineInfo.Credentials.Private = base64.StdEncoding.EncodeToString([]byte("key"))
+	t.Setenv("MOB_TEST_GITLAB_TOKEN", "token")
+	settings.Gitlab.Token = "env:MOB_TEST_GITLAB_TOKEN"
+	if err := settings.prepare(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if settings.MachineInfo.InstanceType != "t3.large" {
+		t.Errorf("expected default instance type, got %q", settings.MachineInfo.InstanceType)
+	}
+	if settings.MachineInfo.UserName != "coder" {
+		t.Errorf("expected default user name, got %q", settings.MachineInfo.UserName)
+	}
+	if settings.MachineInfo.OsDist != "ubuntu" || settings.MachineInfo.LoginUser != "ubuntu" {
+		t.Errorf("expected the default distribution and its login user, got %q and %q",
+			settings.MachineInfo.OsDist, settings.MachineInfo.LoginUser)
+	}
+	if settings.MachineInfo.ResourceType != "ec2" {
+		t.Errorf("expected normalized resource type, got %q", settings.MachineInfo.ResourceType)
+	}
+	if settings.MachineInfo.Credentials.Private != "key" {
+		t.Errorf("expected decoded private key, got %q", settings.MachineInfo.Credentials.Private)
+	}
+	if settings.Gitlab.Token != "token" {
+		t.Errorf("expected resolved gitlab token, got %q", settings.Gitlab.Token)
+	}
+	if settings.DomainName != "cod.dev.example.com" {
+		t.Errorf("unexpected domain name %q", settings.DomainName)
+	}
+}
+
+func TestResourceTags(t *testing.T) {
+	settings := validSettings()
+	settings.Project = "mob-server"
+	settings.Stack = "dev"
+	settings.Expires = "2024-12-31"
+	settings.Tags = map[string]string{"cost-center": "rnd", "Owner": "platform@email.com"}
+
+	expected := map[string]string{
+		"Name":        "cod.dev.example.com",
+		"Project":     "mob-server",
+		"Stack":       "dev",
+		"Owner":       "platform@email.com",
+		"Expiry":      "2024-12-31",
+		"cost-center": "rnd",
+	}
+	if tags := settings.ResourceTags("cod.dev.example.com"); !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected %v, got %v", expected, tags)
+	}
+
+	settings.Expires = ""
+	if _, ok := settings.ResourceTags("cod")["Expiry"]; ok {
+		t.Errorf("expected no Expiry tag without an expiry date")
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	settings := validSettings()
+	settings.Github.Token = PulumiSecret
+	settings.MachineInfo.Credentials.Private = "key"
+	expected := []string{"key", "mygitlabtoken"}
+	if secrets := settings.Secrets(); !reflect.DeepEqual(secrets, expected) {
+		t.Errorf("expected %v, got %v", expected, secrets)
+	}
+}
@@ -45,6 +45,7 @@ type Field struct {
 }
 
 // TryWriteSshConfigFile will try to create, or append to .ssh/config
+// the entry logging in as username (the login user of the distribution)
 // if the entry exist - no update is performed
 func TryWriteSshConfigFile(username string, sshDirectory string, certFileName string) error {
 	configFile := path.Join(sshDirectory, "config")
@@ -71,7 +72,7 @@ func TryWriteSshConfigFile(username string, sshDirectory string, certFileName st
 		Host: certFileName,
 		Fields: map[string]string{
 			"HostName":     certFileName,
-			"User":         "ubuntu",
+			"User":         username,
 			"Port":         "22",
 			"IdentityFile": path.Join(sshDirectory, certFileName),
 		},
@@ -81,7 +81,7 @@ func TryCreateMachineSshCertificate(settings *config.Settings) error {
 		if err := writeKeyToFile(keyBytes, path.Join(sshDirectory, settings.DomainName)); err != nil {
 			return err
 		}
-		if err := TryWriteSshConfigFile(settings.MachineInfo.UserName, sshDirectory, settings.DomainName); err != nil {
+		if err := TryWriteSshConfigFile(settings.MachineInfo.LoginUser, sshDirectory, settings.DomainName); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,88 @@
+// Package diagnostics collects the state of the machine when a provisioning
+// step fails into a local tarball, for it to be looked at without logging
+// in: the cloud-init log, the code-server and caddy journals, the disk and
+// memory usage, and the output of the step.
+package diagnostics
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+	"github.com/slim-ai/mob-code-server/pkg/templating"
+)
+
+// lines is the end of each log that is kept
+const lines = 500
+
+// Runner runs a command on the machine, see ready.SSH
+type Runner interface {
+	Run(command string) (string, error)
+}
+
+// File is a file of the bundle, the output of a command on the machine
+type File struct {
+	Name    string
+	Command string
+}
+
+// Files returns the files collected from the machine
+func Files(settings *config.Settings) []File {
+	return []File{
+		{"cloud-init-output.log", fmt.Sprintf("sudo tail -n %d /var/log/cloud-init-output.log", lines)},
+		{"code-server.journal", fmt.Sprintf("sudo journalctl --no-pager -n %d -u %s", lines,
+			templating.Quote("code-server@"+settings.MachineInfo.UserName))},
+		{"caddy.journal", fmt.Sprintf("sudo journalctl --no-pager -n %d -u caddy", lines)},
+		{"system.txt", "uptime; echo; df -h; echo; free -m; echo; ps aux --sort=-%mem | head -n 20"},
+	}
+}
+
+// Collect writes the bundle of a failed step to dir, named after the step
+// and the time, returning its path. stepOutput is the output of the step.
+// The secrets of the settings are masked, and a command failing on the
+// machine only adds its error to its file.
+func Collect(runner Runner, settings *config.Settings, dir string, step string, stepOutput string, now time.Time) (string, error) {
+	mask := settings.MaskSecrets()
+	var buffer bytes.Buffer
+	compressed := gzip.NewWriter(&buffer)
+	archive := tar.NewWriter(compressed)
+	add := func(name string, content string) error {
+		header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), ModTime: now}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := archive.Write([]byte(content))
+		return err
+	}
+	if err := add(step+".log", mask.Replace(stepOutput)); err != nil {
+		return "", err
+	}
+	for _, file := range Files(settings) {
+		output, err := runner.Run(file.Command)
+		if err != nil {
+			output += fmt.Sprintf("\n(%s failed: %v)\n", file.Command, err)
+		}
+		if err := add(file.Name, mask.Replace(output)); err != nil {
+			return "", err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return "", err
+	}
+	if err := compressed.Close(); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s.tar.gz", strings.TrimSuffix(step, ".sh"), now.UTC().Format("20060102T150405Z"))
+	path := filepath.Join(dir, name)
+	return path, ioutil.WriteFile(path, buffer.Bytes(), 0600)
+}
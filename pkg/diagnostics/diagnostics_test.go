@@ -0,0 +1,79 @@
+package diagnostics
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+)
+
+// fakeRunner returns the command itself, failing the journals
+type fakeRunner struct{}
+
+func (fakeRunner) Run(command string) (string, error) {
+	if strings.Contains(command, "journalctl") {
+		return "", errors.New("Process exited with status 1")
+	}
+	return "ran " + command + " with ghp_secret\n", nil
+}
+
+func TestCollect(t *testing.T) {
+	settings := &config.Settings{}
+	settings.MachineInfo.UserName = "coder"
+	settings.Github.Token = "ghp_secret"
+	dir := filepath.Join(t.TempDir(), "diagnostics")
+	now := time.Date(2024, 1, 1, 12, 30, 0, 0, time.UTC)
+
+	path, err := Collect(fakeRunner{}, settings, dir, "setup.sh", "cloning with ghp_secret\nfailed\n", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := filepath.Join(dir, "setup-20240101T123000Z.tar.gz"); path != expected {
+		t.Errorf("expected %s, got %s", expected, path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer file.Close()
+	compressed, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	archive := tar.NewReader(compressed)
+	contents := map[string]string{}
+	names := []string{}
+	for {
+		header, err := archive.Next()
+		if err != nil {
+			break
+		}
+		b, _ := ioutil.ReadAll(archive)
+		names = append(names, header.Name)
+		contents[header.Name] = string(b)
+	}
+	expectedNames := []string{"setup.sh.log", "cloud-init-output.log", "code-server.journal", "caddy.journal", "system.txt"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Fatalf("expected %v, got %v", expectedNames, names)
+	}
+	for name, content := range contents {
+		if strings.Contains(content, "ghp_secret") {
+			t.Errorf("%s: the secret isn't masked: %q", name, content)
+		}
+	}
+	if contents["setup.sh.log"] != "cloning with ********\nfailed\n" {
+		t.Errorf("unexpected step output %q", contents["setup.sh.log"])
+	}
+	if journal := contents["code-server.journal"]; !strings.Contains(journal, "-u 'code-server@coder'") ||
+		!strings.Contains(journal, "failed: Process exited with status 1") {
+		t.Errorf("unexpected journal %q", journal)
+	}
+}
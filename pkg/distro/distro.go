@@ -0,0 +1,172 @@
+// Package distro is the registry of the linux distributions the machine
+// can run, selected by instance.os_dist: how to find their AMI, which user
+// they let in over ssh, and where their scripts are.
+package distro
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var ErrUnsupportedDistribution = errors.New("unsupported linux distribution")
+
+// The AMI architectures
+const (
+	X86_64 = "x86_64"
+	Arm64  = "arm64"
+)
+
+// Distribution is a linux distribution the machine can run
+type Distribution interface {
+	// Name is the instance.os_dist value, eg. ubuntu-22.04
+	Name() string
+	// AmiQuery returns how to find the AMIs for an architecture, the latest
+	// one is used
+	AmiQuery(architecture string) AmiQuery
+	// LoginUser is the user the AMI lets in over ssh
+	LoginUser() string
+	// PackageManager is eg. apt or dnf
+	PackageManager() string
+	// ScriptsDir is the directory of its scripts in the scripts tree, which
+	// may be shared by several distributions
+	ScriptsDir() string
+	// Architectures are the supported ones, eg. x86_64
+	Architectures() []string
+}
+
+// AmiQuery finds AMIs by owner and filters, as in aws ec2 describe-images
+type AmiQuery struct {
+	Owner   string
+	Filters map[string][]string
+}
+
+// distribution is a Distribution whose AMI names only differ by the
+// architecture, named as in amiArchitectures
+type distribution struct {
+	name             string
+	owner            string
+	amiName          string // with %s for the architecture
+	amiArchitectures map[string]string
+	loginUser        string
+	packageManager   string
+	scriptsDir       string
+}
+
+func (d distribution) Name() string           { return d.name }
+func (d distribution) LoginUser() string      { return d.loginUser }
+func (d distribution) PackageManager() string { return d.packageManager }
+func (d distribution) ScriptsDir() string     { return d.scriptsDir }
+
+func (d distribution) Architectures() []string {
+	architectures := []string{}
+	for architecture := range d.amiArchitectures {
+		architectures = append(architectures, architecture)
+	}
+	sort.Strings(architectures)
+	return architectures
+}
+
+func (d distribution) AmiQuery(architecture string) AmiQuery {
+	return AmiQuery{
+		Owner: d.owner,
+		Filters: map[string][]string{
+			"architecture": {architecture},
+			"name":         {fmt.Sprintf(d.amiName, d.amiArchitectures[architecture])},
+		},
+	}
+}
+
+var (
+	registry = map[string]Distribution{}
+	aliases  = map[string]string{}
+)
+
+// Default is the distribution used when instance.os_dist is not set
+const Default = "ubuntu"
+
+// AWS accounts publishing the AMIs
+const (
+	canonical = "099720109477"
+	debian    = "136693071363"
+	amazon    = "137112412989"
+)
+
+func init() {
+	ubuntuArchitectures := map[string]string{X86_64: "amd64", Arm64: "arm64"}
+	for _, d := range []distribution{
+		{name: "ubuntu-20.04", owner: canonical, amiName: "ubuntu/images/hvm-ssd/ubuntu-focal-20.04-%s-server-*"},
+		{name: "ubuntu-22.04", owner: canonical, amiName: "ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-%s-server-*"},
+		{name: "ubuntu-24.04", owner: canonical, amiName: "ubuntu/images/hvm-ssd-gp3/ubuntu-noble-24.04-%s-server-*"},
+	} {
+		d.amiArchitectures, d.loginUser, d.packageManager, d.scriptsDir = ubuntuArchitectures, "ubuntu", "apt", "ubuntu"
+		Register(d)
+	}
+	// apt based as well, it shares the ubuntu scripts
+	Register(distribution{name: "debian-12", owner: debian, amiName: "debian-12-%s-*",
+		amiArchitectures: ubuntuArchitectures, loginUser: "admin", packageManager: "apt", scriptsDir: "ubuntu"})
+	Register(distribution{name: "amazon-linux-2023", owner: amazon, amiName: "al2023-ami-2023.*-kernel-*-%s",
+		amiArchitectures: map[string]string{X86_64: "x86_64", Arm64: "arm64"},
+		loginUser:        "ec2-user", packageManager: "dnf", scriptsDir: "amazon-linux"})
+	// the distribution of the machines deployed before os_dist had a version
+	Alias("ubuntu", "ubuntu-20.04")
+}
+
+// Register adds or replaces a distribution
+func Register(d Distribution) {
+	registry[d.Name()] = d
+}
+
+// Alias lets name stand for a registered distribution
+func Alias(name string, target string) {
+	aliases[name] = target
+}
+
+// Names returns the registered distributions and aliases, sorted
+func Names() []string {
+	names := []string{}
+	for name := range registry {
+		names = append(names, name)
+	}
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the distribution of an instance.os_dist value
+func Lookup(name string) (Distribution, error) {
+	name = strings.ToLower(name)
+	if target, ok := aliases[name]; ok {
+		name = target
+	}
+	d, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, expected one of %s", ErrUnsupportedDistribution, name, strings.Join(Names(), ", "))
+	}
+	return d, nil
+}
+
+// graviton matches the AWS Graviton instance families, eg. a1, t4g or c7gn
+var graviton = regexp.MustCompile(`^(a1|[a-z]+[0-9]+[a-z]*g[a-z]*(-[a-z]+)?)\.`)
+
+// Architecture returns the architecture of an instance type
+func Architecture(instanceType string) string {
+	if graviton.MatchString(instanceType) {
+		return Arm64
+	}
+	return X86_64
+}
+
+// Supports returns whether the distribution runs on an architecture
+func Supports(d Distribution, architecture string) bool {
+	for _, supported := range d.Architectures() {
+		if supported == architecture {
+			return true
+		}
+	}
+	return false
+}
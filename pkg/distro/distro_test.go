@@ -0,0 +1,71 @@
+package distro
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	testCases := []struct {
+		name         string
+		expectedName string
+		expectedUser string
+	}{
+		{name: "ubuntu", expectedName: "ubuntu-20.04", expectedUser: "ubuntu"},
+		{name: "Ubuntu-22.04", expectedName: "ubuntu-22.04", expectedUser: "ubuntu"},
+		{name: "debian-12", expectedName: "debian-12", expectedUser: "admin"},
+		{name: "amazon-linux-2023", expectedName: "amazon-linux-2023", expectedUser: "ec2-user"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := Lookup(tc.name)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if d.Name() != tc.expectedName || d.LoginUser() != tc.expectedUser {
+				t.Errorf("expected %s logging in as %s, got %s and %s", tc.expectedName, tc.expectedUser, d.Name(), d.LoginUser())
+			}
+		})
+	}
+	if _, err := Lookup("arch"); !errors.Is(err, ErrUnsupportedDistribution) {
+		t.Errorf("expected ErrUnsupportedDistribution, got %v", err)
+	}
+}
+
+func TestArchitecture(t *testing.T) {
+	for instanceType, expected := range map[string]string{
+		"t3.large":    X86_64,
+		"t3a.xlarge":  X86_64,
+		"g4dn.xlarge": X86_64,
+		"c5n.large":   X86_64,
+		"t4g.large":   Arm64,
+		"m6gd.large":  Arm64,
+		"c7gn.large":  Arm64,
+		"a1.medium":   Arm64,
+	} {
+		if architecture := Architecture(instanceType); architecture != expected {
+			t.Errorf("expected %s to be %s, got %s", instanceType, expected, architecture)
+		}
+	}
+}
+
+func TestAmiQuery(t *testing.T) {
+	d, err := Lookup("ubuntu-22.04")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := AmiQuery{
+		Owner: canonical,
+		Filters: map[string][]string{
+			"architecture": {Arm64},
+			"name":         {"ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-arm64-server-*"},
+		},
+	}
+	if query := d.AmiQuery(Arm64); !reflect.DeepEqual(query, expected) {
+		t.Errorf("expected %+v, got %+v", expected, query)
+	}
+	if !Supports(d, X86_64) || !reflect.DeepEqual(d.Architectures(), []string{Arm64, X86_64}) {
+		t.Errorf("unexpected architectures %v", d.Architectures())
+	}
+}
@@ -0,0 +1,206 @@
+// Package health checks the machine once provisioned: over ssh that the
+// code-server and caddy services are active and the repositories cloned,
+// and over HTTPS that caddy serves a valid certificate for the domain name.
+package health
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/remote"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/slim-ai/mob-code-server/pkg/config"
+	"github.com/slim-ai/mob-code-server/pkg/templating"
+)
+
+// linePrefix starts the lines of the ssh checks output
+const linePrefix = "mob-health: "
+
+// Check is the result of a health check
+type Check struct {
+	Name    string
+	Ok      bool
+	Message string
+}
+
+// Report is the result of all the health checks
+type Report struct {
+	Checks []Check
+}
+
+// Healthy returns whether every check passed
+func (report Report) Healthy() bool {
+	for _, check := range report.Checks {
+		if !check.Ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Output returns the report as a stack output
+func (report Report) Output() map[string]interface{} {
+	checks := map[string]interface{}{}
+	for _, check := range report.Checks {
+		checks[check.Name] = map[string]interface{}{"ok": check.Ok, "message": check.Message}
+	}
+	return map[string]interface{}{"healthy": report.Healthy(), "checks": checks}
+}
+
+// Error lists the failed checks
+func (report Report) Error() error {
+	failed := []string{}
+	for _, check := range report.Checks {
+		if !check.Ok {
+			failed = append(failed, fmt.Sprintf("%s: %s", check.Name, check.Message))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("health checks failed:\n  - %s", strings.Join(failed, "\n  - "))
+}
+
+// repositoryDir returns the directory setup_git_repos clones a repository
+// to, eg. api for git@gitlab.com:org/api.git
+func repositoryDir(repository string) string {
+	repository = strings.TrimSuffix(strings.TrimRight(repository, "/"), ".git")
+	if i := strings.LastIndexAny(repository, "/:"); i >= 0 {
+		repository = repository[i+1:]
+	}
+	return repository
+}
+
+// sshScript returns the script checking the machine, printing a line per
+// check. It always succeeds.
+func sshScript(settings *config.Settings) string {
+	username := settings.MachineInfo.UserName
+	lines := []string{"check() {",
+		`    local name=$1`,
+		`    shift`,
+		`    if "$@" > /dev/null 2>&1; then`,
+		`        echo "` + linePrefix + `$name ok"`,
+		`    else`,
+		`        echo "` + linePrefix + `$name failed $*"`,
+		`    fi`,
+		"}",
+		"check code-server systemctl is-active --quiet " + templating.Quote("code-server@"+username),
+		"check caddy systemctl is-active --quiet caddy",
+	}
+	repositories := []string{}
+	for _, cvs := range []config.ConcurrentVersionsSystemInfo{settings.Gitlab, settings.Github} {
+		if cvs.Enabled {
+			repositories = append(repositories, cvs.Repositories...)
+		}
+	}
+	for _, repository := range repositories {
+		dir := path.Join("/home", username, "code", repositoryDir(repository), ".git")
+		lines = append(lines, fmt.Sprintf("check %s test -d %s",
+			templating.Quote("repository "+repositoryDir(repository)), templating.Quote(dir)))
+	}
+	return strings.Join(append(lines, "exit 0"), "\n") + "\n"
+}
+
+// parseSSH reads the checks printed by sshScript
+func parseSSH(stdout string) []Check {
+	checks := []Check{}
+	for _, line := range strings.Split(stdout, "\n") {
+		if !strings.HasPrefix(line, linePrefix) {
+			continue
+		}
+		line = strings.TrimPrefix(line, linePrefix)
+		switch {
+		case strings.HasSuffix(line, " ok"):
+			checks = append(checks, Check{Name: strings.TrimSuffix(line, " ok"), Ok: true})
+		case strings.Contains(line, " failed "):
+			i := strings.Index(line, " failed ")
+			checks = append(checks, Check{Name: line[:i], Message: line[i+len(" failed "):] + " failed"})
+		}
+	}
+	return checks
+}
+
+// CheckHTTPS checks that the server at address (host:port) has a valid
+// certificate for domainName, and that code-server answers its health
+// endpoint. roots are the system ones when nil.
+func CheckHTTPS(address string, domainName string, roots *x509.CertPool, now time.Time) Check {
+	check := Check{Name: "https"}
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ServerName: domainName, RootCAs: roots},
+		},
+	}
+	response, err := client.Get(fmt.Sprintf("https://%s/healthz", address))
+	if err != nil {
+		check.Message = err.Error()
+		return check
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		check.Message = fmt.Sprintf("code-server answered %s", response.Status)
+		return check
+	}
+	expiry := response.TLS.PeerCertificates[0].NotAfter
+	check.Ok = true
+	check.Message = fmt.Sprintf("certificate valid for %d days", int(expiry.Sub(now).Hours()/24))
+	return check
+}
+
+// httpsAttempts and httpsDelay give caddy time to load the certificate
+const (
+	httpsAttempts = 6
+	httpsDelay    = 10 * time.Second
+)
+
+// Run checks the machine at host (its public IP) once ready resolves, again
+// when triggers change, eg. the instance or the provisioning steps. The
+// returned output is the report, failing when a check does and
+// settings.health.fail_deploy is set.
+func Run(ctx *pulumi.Context, settings *config.Settings, host pulumi.StringOutput, ready pulumi.StringOutput, triggers pulumi.ArrayInput, dependsOns []pulumi.Resource) (pulumi.MapOutput, error) {
+	script := sshScript(settings)
+	cmd, err := remote.NewCommand(ctx, "health-check", &remote.CommandArgs{
+		Connection: remote.ConnectionArgs{
+			Host:       host,
+			Port:       pulumi.Float64(22),
+			PrivateKey: pulumi.String(settings.MachineInfo.Credentials.Private),
+			User:       pulumi.String(settings.MachineInfo.LoginUser),
+		},
+		Create:   ready.ApplyT(func(string) string { return script }).(pulumi.StringOutput),
+		Triggers: triggers,
+	}, pulumi.DependsOn(dependsOns))
+	if err != nil {
+		return pulumi.MapOutput{}, err
+	}
+	return cmd.Stdout.ApplyT(func(stdout string) (map[string]interface{}, error) {
+		report := Report{Checks: parseSSH(stdout)}
+		https := Check{}
+		for attempt := 1; attempt <= httpsAttempts; attempt++ {
+			if https = CheckHTTPS(settings.DomainName+":443", settings.DomainName, nil, time.Now()); https.Ok {
+				break
+			}
+			if attempt < httpsAttempts {
+				time.Sleep(httpsDelay)
+			}
+		}
+		report.Checks = append(report.Checks, https)
+		sort.SliceStable(report.Checks, func(i, j int) bool { return report.Checks[i].Name < report.Checks[j].Name })
+		for _, check := range report.Checks {
+			status := "ok"
+			if !check.Ok {
+				status = "FAILED"
+			}
+			pulumi.Printf("Health check [%s] %s %s\n", check.Name, status, check.Message)
+		}
+		if err := report.Error(); err != nil && settings.Health.FailDeploy {
+			return nil, err
+		}
+		return report.Output(), nil
+	}).(pulumi.MapOutput), nil
+}
@@ -0,0 +1,94 @@
+package health
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+)
+
+func TestRepositoryDir(t *testing.T) {
+	for repository, expected := range map[string]string{
+		"git@gitlab.com:org/api.git":       "api",
+		"https://github.com/org/web":       "web",
+		"https://github.com/org/web.git/":  "web",
+		"git@gitlab.com:group/sub/cli.git": "cli",
+	} {
+		if actual := repositoryDir(repository); actual != expected {
+			t.Errorf("expected %q for %s, got %q", expected, repository, actual)
+		}
+	}
+}
+
+func TestSSHChecks(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	// only caddy is active
+	bin := t.TempDir()
+	systemctl := "#!/bin/sh\n[ \"$3\" = caddy ]\n"
+	if err := os.WriteFile(filepath.Join(bin, "systemctl"), []byte(systemctl), 0755); err != nil {
+		t.Fatal(err)
+	}
+	settings := &config.Settings{
+		MachineInfo: config.MachineInfo{UserName: "coder"},
+		Gitlab:      config.ConcurrentVersionsSystemInfo{Enabled: true, Repositories: []string{"git@gitlab.com:org/api.git"}},
+		Github:      config.ConcurrentVersionsSystemInfo{Repositories: []string{"https://github.com/org/web"}},
+	}
+	cmd := exec.Command("bash", "-c", sshScript(settings))
+	cmd.Env = append(os.Environ(), "PATH="+bin+":"+os.Getenv("PATH"))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, output)
+	}
+	report := Report{Checks: parseSSH(string(output))}
+	expected := []Check{
+		{Name: "code-server", Message: "systemctl is-active --quiet code-server@coder failed"},
+		{Name: "caddy", Ok: true},
+		{Name: "repository api", Message: "test -d /home/coder/code/api/.git failed"},
+	}
+	if len(report.Checks) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, report.Checks)
+	}
+	for i := range expected {
+		if report.Checks[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], report.Checks[i])
+		}
+	}
+	if report.Healthy() || !strings.Contains(report.Error().Error(), "code-server: systemctl") {
+		t.Errorf("unexpected report error %v", report.Error())
+	}
+}
+
+func TestCheckHTTPS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"status":"alive"}`))
+	}))
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	// the test certificate is for example.com
+	check := CheckHTTPS(address, "example.com", roots, time.Now())
+	if !check.Ok || !strings.HasPrefix(check.Message, "certificate valid for") {
+		t.Errorf("unexpected check %+v", check)
+	}
+	if check := CheckHTTPS(address, "dev.example.org", roots, time.Now()); check.Ok || !strings.Contains(check.Message, "certificate") {
+		t.Errorf("expected the wrong domain name to fail, got %+v", check)
+	}
+	if check := CheckHTTPS(address, "example.com", nil, time.Now()); check.Ok {
+		t.Errorf("expected an untrusted certificate to fail, got %+v", check)
+	}
+}
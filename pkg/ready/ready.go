@@ -0,0 +1,188 @@
+// Package ready waits for a new machine to be ready for provisioning: its
+// public IP accepting the generated ssh key, and cloud-init done with the
+// user-data scripts.
+package ready
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/slim-ai/mob-code-server/pkg/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// The polling of the machine, the delay doubling up to maxDelay
+const (
+	timeout      = 20 * time.Minute
+	firstDelay   = 5 * time.Second
+	maxDelay     = 30 * time.Second
+	dialTimeout  = 10 * time.Second
+	cloudInitLog = "/var/log/cloud-init-output.log"
+	logLines     = 20
+)
+
+// statusScript prints the cloud-init status, eg. "status: running", and
+// "status: disabled" where there is no cloud-init
+const statusScript = `command -v cloud-init > /dev/null || { echo "status: disabled"; exit 0; }
+cloud-init status 2>&1 || true
+`
+
+// Status is the state of the machine as seen by a probe
+type Status struct {
+	Reachable bool   // ssh accepted the key
+	CloudInit string // eg. running, done or error
+	Err       error  // why it's not reachable
+}
+
+// Ready returns whether provisioning can start
+func (status Status) Ready() bool {
+	return status.Reachable && (status.CloudInit == "done" || status.CloudInit == "disabled")
+}
+
+// Failed returns whether cloud-init gave up, eg. when a user-data script
+// failed
+func (status Status) Failed() bool {
+	return status.Reachable && status.CloudInit == "error"
+}
+
+func (status Status) String() string {
+	if !status.Reachable {
+		return fmt.Sprintf("ssh: %v", status.Err)
+	}
+	return "cloud-init " + status.CloudInit
+}
+
+// parseCloudInit reads the output of statusScript
+func parseCloudInit(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "status:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "status:"))
+		}
+	}
+	return "unknown"
+}
+
+// Machine is what Poll needs of the machine
+type Machine interface {
+	Probe() Status
+	// LogTail returns the end of the cloud-init log
+	LogTail() string
+}
+
+// Poll probes the machine with backoff until it is ready, returning an
+// error with the end of the cloud-init log when cloud-init failed or the
+// machine isn't ready after timeout
+func Poll(machine Machine, timeout time.Duration, sleep func(time.Duration), now func() time.Time) error {
+	deadline := now().Add(timeout)
+	delay := firstDelay
+	status := Status{}
+	for {
+		if status = machine.Probe(); status.Ready() {
+			return nil
+		}
+		if status.Failed() {
+			return fmt.Errorf("cloud-init failed on the machine, the end of %s:\n%s", cloudInitLog, machine.LogTail())
+		}
+		if !now().Add(delay).Before(deadline) {
+			break
+		}
+		pulumi.Printf("Waiting for the machine: %s, next attempt in %s\n", status, delay)
+		sleep(delay)
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+	message := fmt.Sprintf("the machine is not ready after %s, %s", timeout, status)
+	if status.Reachable {
+		message += fmt.Sprintf(", the end of %s:\n%s", cloudInitLog, machine.LogTail())
+	}
+	return fmt.Errorf("%s", message)
+}
+
+// SSH runs commands on the machine as the login user
+type SSH struct {
+	address string
+	config  *ssh.ClientConfig
+}
+
+// NewSSH returns the ssh client of the machine at host, with the generated
+// key
+func NewSSH(settings *config.Settings, host string) (SSH, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(settings.MachineInfo.Credentials.Private))
+	if err != nil {
+		return SSH{}, fmt.Errorf("settings.instance.credentials.private: %w", err)
+	}
+	return SSH{
+		address: net.JoinHostPort(host, "22"),
+		config: &ssh.ClientConfig{
+			User: settings.MachineInfo.LoginUser,
+			Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			// a new machine, with a new host key on each deploy
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         dialTimeout,
+		},
+	}, nil
+}
+
+// Run returns the output of command, stdout and stderr combined
+func (machine SSH) Run(command string) (string, error) {
+	client, err := ssh.Dial("tcp", machine.address, machine.config)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	var output bytes.Buffer
+	session.Stdout = &output
+	session.Stderr = &output
+	err = session.Run(command)
+	return output.String(), err
+}
+
+// Probe returns the status of the machine
+func (machine SSH) Probe() Status {
+	output, err := machine.Run(statusScript)
+	if err != nil {
+		return Status{Err: err}
+	}
+	return Status{Reachable: true, CloudInit: parseCloudInit(output)}
+}
+
+// LogTail returns the end of the cloud-init log
+func (machine SSH) LogTail() string {
+	output, err := machine.Run(fmt.Sprintf("sudo tail -n %d %s", logLines, cloudInitLog))
+	if err != nil {
+		return fmt.Sprintf("(unavailable: %v)", err)
+	}
+	return output
+}
+
+// Wait returns the public IP of the machine once it is ready, failing when
+// it doesn't become ready. Nothing is waited for on preview.
+func Wait(ctx *pulumi.Context, settings *config.Settings, publicIp pulumi.StringOutput) (pulumi.StringOutput, error) {
+	// checks the key before deploying anything
+	if _, err := NewSSH(settings, ""); err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	return publicIp.ApplyT(func(ip string) (string, error) {
+		if ctx.DryRun() {
+			return ip, nil
+		}
+		machine, err := NewSSH(settings, ip)
+		if err != nil {
+			return "", err
+		}
+		if err := Poll(machine, timeout, time.Sleep, time.Now); err != nil {
+			return "", err
+		}
+		return ip, nil
+	}).(pulumi.StringOutput), nil
+}
@@ -0,0 +1,104 @@
+package ready
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMachine returns its statuses in turn, then the last one
+type fakeMachine struct {
+	statuses []Status
+	probes   int
+}
+
+func (machine *fakeMachine) Probe() Status {
+	status := machine.statuses[len(machine.statuses)-1]
+	if machine.probes < len(machine.statuses) {
+		status = machine.statuses[machine.probes]
+	}
+	machine.probes++
+	return status
+}
+
+func (machine *fakeMachine) LogTail() string {
+	return "Cloud-init v. 22.4 finished\n"
+}
+
+func TestPoll(t *testing.T) {
+	refused := Status{Err: errors.New("connection refused")}
+	running := Status{Reachable: true, CloudInit: "running"}
+	testCases := []struct {
+		name           string
+		statuses       []Status
+		expectedSleeps []time.Duration
+		expectedErr    string
+	}{
+		{
+			name:           "ready",
+			statuses:       []Status{refused, refused, running, running, {Reachable: true, CloudInit: "done"}},
+			expectedSleeps: []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 30 * time.Second},
+		},
+		{
+			name:     "without cloud-init",
+			statuses: []Status{{Reachable: true, CloudInit: "disabled"}},
+		},
+		{
+			name:           "cloud-init failed",
+			statuses:       []Status{running, {Reachable: true, CloudInit: "error"}},
+			expectedSleeps: []time.Duration{5 * time.Second},
+			expectedErr:    "cloud-init failed on the machine, the end of /var/log/cloud-init-output.log:\nCloud-init v. 22.4 finished",
+		},
+		{
+			name:        "never reachable",
+			statuses:    []Status{refused},
+			expectedErr: "the machine is not ready after 2m0s, ssh: connection refused",
+		},
+		{
+			name:        "cloud-init never done",
+			statuses:    []Status{running},
+			expectedErr: "the machine is not ready after 2m0s, cloud-init running, the end of",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			sleeps := []time.Duration{}
+			sleep := func(delay time.Duration) {
+				sleeps = append(sleeps, delay)
+				clock = clock.Add(delay)
+			}
+			err := Poll(&fakeMachine{statuses: tc.statuses}, 2*time.Minute, sleep, func() time.Time { return clock })
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected an error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(sleeps) > 0 || len(tc.expectedSleeps) > 0 {
+				if !reflect.DeepEqual(sleeps, tc.expectedSleeps) {
+					t.Errorf("expected sleeps %v, got %v", tc.expectedSleeps, sleeps)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCloudInit(t *testing.T) {
+	for output, expected := range map[string]string{
+		"status: done\n":                        "done",
+		"\nstatus: running\n":                   "running",
+		"status: error\n":                       "error",
+		"bash: cloud-init: command not found\n": "unknown",
+	} {
+		if status := parseCloudInit(output); status != expected {
+			t.Errorf("expected %q for %q, got %q", expected, output, status)
+		}
+	}
+}
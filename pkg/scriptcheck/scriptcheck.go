@@ -0,0 +1,118 @@
+// Package scriptcheck parses the rendered bash scripts and cloud-config
+// documents before they are sent to the machine, so that mistakes are found
+// during preview instead of minutes into a deploy.
+package scriptcheck
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/slim-ai/mob-code-server/pkg/templating"
+	"gopkg.in/yaml.v2"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+var (
+	// eg. ___DOMAIN_NAME___, left by a value rendering another placeholder
+	placeholderPattern = regexp.MustCompile(`___[A-Z0-9][A-Z0-9_]*?___`)
+	// the scripts name their functions like install_go, commands rarely
+	// have underscores
+	functionPattern = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)+$`)
+	// the line of a yaml error, eg. "yaml: line 3: mapping values are not
+	// allowed in this context"
+	yamlLinePattern = regexp.MustCompile(`^yaml: line (\d+): `)
+)
+
+// cloudConfigHeader starts a cloud-config document
+const cloudConfigHeader = "#cloud-config"
+
+// knownCommands are the commands looking like script functions
+var knownCommands = map[string]bool{
+	"lsb_release": true,
+}
+
+// Check reports the syntax errors, the calls to functions the script
+// doesn't define and the leftover placeholders of a rendered script. The
+// provided functions are defined for the script by whatever runs it.
+func Check(name string, script string, provided ...string) templating.Problems {
+	var problems templating.Problems
+	for i, line := range strings.Split(script, "\n") {
+		for _, placeholder := range placeholderPattern.FindAllString(line, -1) {
+			problems = append(problems, templating.Problem{
+				Script: name, Line: i + 1, Message: fmt.Sprintf("%s was left unresolved", placeholder),
+			})
+		}
+	}
+
+	file, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(script), name)
+	if err != nil {
+		line := 0
+		if parseErr, ok := err.(syntax.ParseError); ok {
+			line = int(parseErr.Pos.Line())
+			err = fmt.Errorf("%s", parseErr.Text)
+		}
+		return append(problems, templating.Problem{Script: name, Line: line, Message: fmt.Sprintf("syntax error: %v", err)})
+	}
+
+	defined := map[string]bool{}
+	for _, function := range provided {
+		defined[function] = true
+	}
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if decl, ok := node.(*syntax.FuncDecl); ok {
+			defined[decl.Name.Value] = true
+		}
+		return true
+	})
+	syntax.Walk(file, func(node syntax.Node) bool {
+		call, ok := node.(*syntax.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		command := call.Args[0].Lit()
+		if functionPattern.MatchString(command) && !defined[command] && !knownCommands[command] {
+			problems = append(problems, templating.Problem{
+				Script:  name,
+				Line:    int(call.Pos().Line()),
+				Message: fmt.Sprintf("%s is not a function defined by the script", command),
+			})
+		}
+		return true
+	})
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+	return problems
+}
+
+// CheckCloudConfig reports the leftover placeholders of a rendered
+// cloud-config document, a missing #cloud-config header, and yaml that
+// isn't a mapping
+func CheckCloudConfig(name string, document string) templating.Problems {
+	var problems templating.Problems
+	for i, line := range strings.Split(document, "\n") {
+		for _, placeholder := range placeholderPattern.FindAllString(line, -1) {
+			problems = append(problems, templating.Problem{
+				Script: name, Line: i + 1, Message: fmt.Sprintf("%s was left unresolved", placeholder),
+			})
+		}
+	}
+	if strings.TrimSpace(strings.SplitN(document, "\n", 2)[0]) != cloudConfigHeader {
+		problems = append(problems, templating.Problem{
+			Script: name, Line: 1, Message: fmt.Sprintf("a cloud-config document must start with %s", cloudConfigHeader),
+		})
+	}
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(document), &config); err != nil {
+		line := 0
+		message := err.Error()
+		if match := yamlLinePattern.FindStringSubmatch(message); match != nil {
+			line, _ = strconv.Atoi(match[1])
+			message = strings.TrimPrefix(message, match[0])
+		}
+		problems = append(problems, templating.Problem{Script: name, Line: line, Message: fmt.Sprintf("yaml error: %s", message)})
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+	return problems
+}
@@ -0,0 +1,100 @@
+package scriptcheck
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	testCases := []struct {
+		name     string
+		script   string
+		provided []string
+		expected []string
+	}{
+		{
+			name: "valid script",
+			script: `#!/usr/bin/env bash
+install_go() {
+    local version=$1
+    echo "$(lsb_release -cs) $version"
+}
+install_go "1.20"
+`,
+		},
+		{
+			name:     "syntax error",
+			script:   "install_go() {\n    if true; then\n        echo\n}\n",
+			expected: []string{`setup.sh:4: syntax error: "}" can only be used to close a block`},
+		},
+		{
+			name:   "undefined function",
+			script: "install_go() {\n    true\n}\ninstall_go\ninstal_go\nsudo apt-get update\n",
+			expected: []string{
+				"setup.sh:5: instal_go is not a function defined by the script",
+			},
+		},
+		{
+			name:     "provided function",
+			script:   "mob_once install true\n",
+			provided: []string{"mob_once"},
+		},
+		{
+			name:     "leftover placeholders",
+			script:   "echo ok\necho ___GOPRIVATE___\n",
+			expected: []string{"setup.sh:2: ___GOPRIVATE___ was left unresolved"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var found []string
+			for _, problem := range Check("setup.sh", tc.script, tc.provided...) {
+				found = append(found, problem.String())
+			}
+			if !reflect.DeepEqual(found, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, found)
+			}
+		})
+	}
+}
+
+func TestCheckCloudConfig(t *testing.T) {
+	testCases := []struct {
+		name     string
+		document string
+		expected []string
+	}{
+		{
+			name:     "valid document",
+			document: "#cloud-config\npackages:\n  - jq\nruncmd:\n  - [systemctl, restart, sshd]\n",
+		},
+		{
+			name:     "missing header",
+			document: "packages:\n  - jq\n",
+			expected: []string{"cloud.yml:1: a cloud-config document must start with #cloud-config"},
+		},
+		{
+			name:     "yaml error",
+			document: "#cloud-config\npackages:\n  - jq\n runcmd: x\n",
+			expected: []string{"cloud.yml:3: yaml error: did not find expected key"},
+		},
+		{
+			name:     "leftover placeholders",
+			document: "#cloud-config\nhostname: ___HOSTNAME___\n",
+			expected: []string{"cloud.yml:2: ___HOSTNAME___ was left unresolved"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var found []string
+			for _, problem := range CheckCloudConfig("cloud.yml", tc.document) {
+				found = append(found, problem.String())
+			}
+			if !reflect.DeepEqual(found, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, found)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Package secrets resolves references to secrets kept outside of the
+// configuration, such as `env:GITLAB_TOKEN`, `file:~/.tokens/gitlab`,
+// `sops:secrets.enc.yaml#gitlab.token` or `age:gitlab.token.age`.
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrUnknownScheme = errors.New("unknown secret scheme")
+	ErrNotFound      = errors.New("secret not found")
+)
+
+// Resolver returns the secret for the part of a reference after "scheme:"
+type Resolver interface {
+	Resolve(ref string) (string, error)
+}
+
+// ResolverFunc adapts a function to the Resolver interface
+type ResolverFunc func(ref string) (string, error)
+
+func (f ResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+var resolvers = map[string]Resolver{
+	"env":  ResolverFunc(resolveEnv),
+	"file": ResolverFunc(resolveFile),
+	"sops": ResolverFunc(resolveSops),
+	"age":  ResolverFunc(resolveAge),
+}
+
+// Register adds or replaces the resolver of a scheme
+func Register(scheme string, resolver Resolver) {
+	resolvers[scheme] = resolver
+}
+
+// Schemes returns the registered schemes, sorted
+func Schemes() []string {
+	schemes := make([]string, 0, len(resolvers))
+	for scheme := range resolvers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// IsReference reports whether value starts with a registered scheme
+func IsReference(value string) bool {
+	scheme, _, ok := split(value)
+	if !ok {
+		return false
+	}
+	_, ok = resolvers[scheme]
+	return ok
+}
+
+// Resolve returns the secret a reference points to. Values which are not
+// references are returned as they are, so literal secrets keep working.
+func Resolve(value string) (string, error) {
+	if !IsReference(value) {
+		return value, nil
+	}
+	scheme, ref, _ := split(value)
+	secret, err := resolvers[scheme].Resolve(ref)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", value, err)
+	}
+	return secret, nil
+}
+
+func split(value string) (scheme string, ref string, ok bool) {
+	i := strings.Index(value, ":")
+	if i <= 0 {
+		return "", "", false
+	}
+	return value[:i], value[i+1:], true
+}
+
+// runCommand runs an external decryption tool, replaceable for tests
+var runCommand = func(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
+
+func resolveEnv(name string) (string, error) {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value, nil
+	}
+	return "", fmt.Errorf("%w: %s is not set", ErrNotFound, name)
+}
+
+func resolveFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(expandHome(path))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// resolveSops decrypts a sops file, optionally extracting the value at a
+// dotted path given after '#'
+func resolveSops(ref string) (string, error) {
+	path, key := ref, ""
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		path, key = ref[:i], ref[i+1:]
+	}
+	args := []string{"--decrypt"}
+	if key != "" {
+		extract := ""
+		for _, part := range strings.Split(key, ".") {
+			extract += fmt.Sprintf("[%q]", part)
+		}
+		args = append(args, "--extract", extract)
+	}
+	out, err := runCommand("sops", append(args, expandHome(path))...)
+	if err != nil {
+		return "", fmt.Errorf("sops: %w", err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// resolveAge decrypts an age file with the identity in SOPS_AGE_KEY_FILE,
+// or the default sops age key file
+func resolveAge(path string) (string, error) {
+	identity := os.Getenv("SOPS_AGE_KEY_FILE")
+	if identity == "" {
+		identity = "~/.config/sops/age/keys.txt"
+	}
+	out, err := runCommand("age", "--decrypt", "--identity", expandHome(identity), expandHome(path))
+	if err != nil {
+		return "", fmt.Errorf("age: %w", err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+	return path
+}
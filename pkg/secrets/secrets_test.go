@@ -0,0 +1,73 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "gitlab")
+	if err := os.WriteFile(tokenFile, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MOB_TEST_TOKEN", "env-token")
+	t.Setenv("MOB_TEST_EMPTY", "")
+
+	var commands [][]string
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, append([]string{name}, args...))
+		return []byte("decrypted\n"), nil
+	}
+
+	testCases := []struct {
+		name        string
+		value       string
+		expected    string
+		expectedCmd []string
+		expectedErr bool
+	}{
+		{name: "literal", value: "glpat-123", expected: "glpat-123"},
+		{name: "unknown scheme is a literal", value: "https://example.com", expected: "https://example.com"},
+		{name: "env", value: "env:MOB_TEST_TOKEN", expected: "env-token"},
+		{name: "unset env", value: "env:MOB_TEST_EMPTY", expectedErr: true},
+		{name: "file", value: "file:" + tokenFile, expected: "file-token"},
+		{name: "missing file", value: "file:" + tokenFile + ".missing", expectedErr: true},
+		{
+			name:        "sops with key",
+			value:       "sops:secrets.enc.yaml#gitlab.token",
+			expected:    "decrypted",
+			expectedCmd: []string{"sops", "--decrypt", "--extract", `["gitlab"]["token"]`, "secrets.enc.yaml"},
+		},
+		{
+			name:        "sops whole file",
+			value:       "sops:key.enc",
+			expected:    "decrypted",
+			expectedCmd: []string{"sops", "--decrypt", "key.enc"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			commands = nil
+			secret, err := Resolve(tc.value)
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", secret)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if secret != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, secret)
+			}
+			if tc.expectedCmd != nil && (len(commands) != 1 || !reflect.DeepEqual(commands[0], tc.expectedCmd)) {
+				t.Errorf("expected command %v, got %v", tc.expectedCmd, commands)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -14,6 +15,7 @@ import (
 	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/route53"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 	"github.com/slim-ai/mob-code-server/pkg/config"
+	"github.com/slim-ai/mob-code-server/pkg/distro"
 )
 
 // GetVpcId returns the provided VpcId after validation,
@@ -87,9 +89,7 @@ func CreateSecurityGroup(ctx *pulumi.Context, settings *config.Settings) (*ec2.S
 					},
 				},
 			},
-			Tags: pulumi.StringMap{
-				"Owner": pulumi.String(settings.MachineInfo.Hostname),
-			},
+			Tags: resourceTags(settings, settings.DomainName),
 		},
 	)
 }
@@ -103,33 +103,37 @@ func CreateNewKeyPair(ctx *pulumi.Context, settings *config.Settings) (*ec2.KeyP
 		&ec2.KeyPairArgs{
 			KeyName:   pulumi.String(name),
 			PublicKey: pulumi.String(settings.MachineInfo.Credentials.Public),
-			Tags: pulumi.StringMap{
-				"Owner": pulumi.String(settings.MachineInfo.Hostname),
-			},
+			Tags:      resourceTags(settings, name),
 		},
 	)
 }
 
+// resourceTags returns the tags every AWS resource gets, see
+// config.Settings.ResourceTags
+func resourceTags(settings *config.Settings, name string) pulumi.StringMap {
+	return pulumi.ToStringMap(settings.ResourceTags(name))
+}
+
 ////////////////////////////////////////////
 
 var (
-	ErrUnsupportedDistribution error = errors.New("unsupported linux distribution")
-	ErrNotFound                error = errors.New("unable to locate an AMI image")
+	ErrNotFound error = errors.New("unable to locate an AMI image")
 )
 
-// GetAmiId returns an AMI ID for the configured OS distribution
+// GetAmiId returns the latest AMI ID of the configured OS distribution, for
+// the architecture of the instance type
 func GetAmiId(ctx *pulumi.Context, settings *config.Settings) error {
-	type AmiSelector func(ctx *pulumi.Context) (*string, error)
-	var selector AmiSelector
-	switch strings.ToLower(settings.MachineInfo.OsDist) {
-	case "ubuntu":
-		selector = selectUbuntuAmiId
-	case "arch":
-		selector = selectArchAmiId
-	default:
-		return ErrUnsupportedDistribution
+	d, err := settings.Distribution()
+	if err != nil {
+		return err
 	}
-	id, err := selector(ctx)
+	query := d.AmiQuery(distro.Architecture(settings.MachineInfo.InstanceType))
+	filters := []ec2.GetAmiIdsFilter{}
+	for name, values := range query.Filters {
+		filters = append(filters, ec2.GetAmiIdsFilter{Name: name, Values: values})
+	}
+	sort.Slice(filters, func(i, j int) bool { return filters[i].Name < filters[j].Name })
+	id, err := selectAmiId(ctx, filters, query.Owner)
 	if err != nil {
 		return err
 	}
@@ -137,23 +141,6 @@ func GetAmiId(ctx *pulumi.Context, settings *config.Settings) error {
 	return nil
 }
 
-func selectUbuntuAmiId(ctx *pulumi.Context) (*string, error) {
-	return selectAmiId(ctx, []ec2.GetAmiIdsFilter{
-		{Name: "architecture", Values: []string{"x86_64"}},
-		{Name: "description", Values: []string{"*LTS*"}},
-		{
-			Name:   "name",
-			Values: []string{"ubuntu/images/hvm-ssd/ubuntu-focal-20.04-amd64-server-*"},
-		},
-	}, "099720109477")
-}
-
-func selectArchAmiId(ctx *pulumi.Context) (*string, error) {
-	return selectAmiId(ctx, []ec2.GetAmiIdsFilter{
-		{Name: "name", Values: []string{"arch-linux-lts-hvm-*.x86_64-ebs"}},
-	}, "093273469852")
-}
-
 func selectAmiId(ctx *pulumi.Context, filters []ec2.GetAmiIdsFilter, ownerId string) (*string, error) {
 	amis, err := ec2.GetAmiIds(ctx, &ec2.GetAmiIdsArgs{
 		ExecutableUsers: []string{},
@@ -212,10 +199,6 @@ func GetVpcIdPublicSubnet(ctx *pulumi.Context, settings *config.Settings) error
 }
 
 func ValidateInstanceType(ctx *pulumi.Context, settings *config.Settings) error {
-	if settings.MachineInfo.InstanceType == "" {
-		settings.MachineInfo.InstanceType = "t3.large"
-	}
-
 	if _, err := ec2.GetInstanceType(ctx,
 		&ec2.GetInstanceTypeArgs{
 			InstanceType: settings.MachineInfo.InstanceType,
@@ -241,7 +224,16 @@ func ValidateInstanceType(ctx *pulumi.Context, settings *config.Settings) error
 	}
 	settings.MachineInfo.SpotPrice = priceInfo.SpotPrice
 	printPricing(ctx, settings)
-	return nil
+
+	// the policy was checked against the settings, instance type included,
+	// when loading them; the market price is only known now
+	if settings.MachineInfo.ResourceType != "spot" {
+		return nil
+	}
+	violations := settings.Policy.CheckSpotMarketPrice(settings.MachineInfo.SpotPrice)
+	return config.Enforce(violations, func(message string) {
+		ctx.Log.Warn(message, nil)
+	})
 }
 
 func printPricing(ctx *pulumi.Context, settings *config.Settings) {
@@ -299,31 +291,34 @@ func printPricing(ctx *pulumi.Context, settings *config.Settings) {
 
 ////////////////////////////////////////////
 
-// Creates an instance provided the settings and userdata script
-func CreateNewInstance(ctx *pulumi.Context, settings *config.Settings, hostedZone *route53.LookupZoneResult, userData *string) (pulumi.Resource, error) {
+// Creates an instance provided the settings and userdata script, returning
+// the DNS record once mapped to it, and its public IP
+func CreateNewInstance(ctx *pulumi.Context, settings *config.Settings, hostedZone *route53.LookupZoneResult, userDataBase64 *string) (pulumi.Resource, pulumi.StringOutput, error) {
 	if err := ValidateInstanceType(ctx, settings); err != nil {
-		return nil, err
+		return nil, pulumi.StringOutput{}, err
 	}
 	if err := GetAmiId(ctx, settings); err != nil {
-		return nil, err
+		return nil, pulumi.StringOutput{}, err
 	}
 	if err := GetVpcId(ctx, settings); err != nil {
-		return nil, err
+		return nil, pulumi.StringOutput{}, err
 	}
 	if err := GetVpcIdPublicSubnet(ctx, settings); err != nil {
-		return nil, err
+		return nil, pulumi.StringOutput{}, err
 	}
 	key, err := CreateNewKeyPair(ctx, settings)
 	if err != nil {
-		return nil, err
+		return nil, pulumi.StringOutput{}, err
 	}
 	group, err := CreateSecurityGroup(ctx, settings)
 	if err != nil {
-		return nil, err
+		return nil, pulumi.StringOutput{}, err
 	}
-	userDataScript := ""
-	if userData != nil && len(*userData) > 0 {
-		userDataScript = *userData
+	// no user-data is kept as an empty string, which doesn't replace
+	// machines deployed before user-data was base64 encoded
+	userData, encodedUserData := pulumi.StringPtr(""), pulumi.StringPtrInput(nil)
+	if userDataBase64 != nil && len(*userDataBase64) > 0 {
+		userData, encodedUserData = nil, pulumi.StringPtr(*userDataBase64)
 	}
 
 	var (
@@ -344,17 +339,20 @@ func CreateNewInstance(ctx *pulumi.Context, settings *config.Settings, hostedZon
 				},
 				KeyName:             key.KeyName,
 				InstanceType:        pulumi.String(settings.MachineInfo.InstanceType),
-				UserData:            pulumi.String(userDataScript),
+				UserData:            userData,
+				UserDataBase64:      encodedUserData,
 				VpcSecurityGroupIds: pulumi.StringArray{group.ID()},
-				Tags: pulumi.StringMap{
-					"Name":  pulumi.String(settings.DomainName),
-					"Owner": pulumi.String(settings.MachineInfo.UserName),
-				},
-				WaitForFulfillment: pulumi.Bool(true),
+				Tags:                resourceTags(settings, settings.DomainName),
+				VolumeTags:          resourceTags(settings, settings.DomainName),
+				WaitForFulfillment:  pulumi.Bool(true),
 			},
 		)
 		if err != nil {
-			return nil, err
+			return nil, pulumi.StringOutput{}, err
+		}
+		// the request tags are not copied to the instance it launches
+		if err := tagSpotInstance(ctx, settings, inst); err != nil {
+			return nil, pulumi.StringOutput{}, err
 		}
 		resource = inst
 		publicIp = &inst.PublicIp
@@ -366,12 +364,14 @@ func CreateNewInstance(ctx *pulumi.Context, settings *config.Settings, hostedZon
 			RootBlockDevice: ec2.InstanceRootBlockDeviceArgs{
 				DeleteOnTermination: pulumi.Bool(true), VolumeSize: pulumi.Int(settings.MachineInfo.DiskSizeGB), VolumeType: pulumi.String("gp3"),
 			},
-			Tags:                pulumi.StringMap{"Name": pulumi.String(settings.DomainName), "Owner": pulumi.String(settings.MachineInfo.UserName)},
-			UserData:            pulumi.String(userDataScript),
+			Tags:                resourceTags(settings, settings.DomainName),
+			VolumeTags:          resourceTags(settings, settings.DomainName),
+			UserData:            userData,
+			UserDataBase64:      encodedUserData,
 			VpcSecurityGroupIds: pulumi.StringArray{group.ID()},
 		})
 		if err != nil {
-			return nil, err
+			return nil, pulumi.StringOutput{}, err
 		}
 		resource = inst
 		publicIp = &inst.PublicIp
@@ -391,9 +391,41 @@ func CreateNewInstance(ctx *pulumi.Context, settings *config.Settings, hostedZon
 		pulumi.DependsOn([]pulumi.Resource{resource}),
 	)
 	if err != nil {
-		return nil, err
+		return nil, pulumi.StringOutput{}, err
 	}
-	return route, nil
+	// and one subdomain per team member
+	for _, member := range settings.Members()[1:] {
+		if _, err := route53.NewRecord(ctx, fmt.Sprintf("%s-route", member.DomainName),
+			&route53.RecordArgs{
+				ZoneId:  pulumi.String(hostedZone.Id),
+				Name:    pulumi.String(member.DomainName),
+				Type:    pulumi.String("A"),
+				Ttl:     pulumi.Int(300),
+				Records: pulumi.StringArray{*publicIp},
+			},
+			pulumi.DependsOn([]pulumi.Resource{resource}),
+		); err != nil {
+			return nil, pulumi.StringOutput{}, err
+		}
+	}
+	return route, *publicIp, nil
+}
+
+// tagSpotInstance tags the instance launched by a spot request
+func tagSpotInstance(ctx *pulumi.Context, settings *config.Settings, request *ec2.SpotInstanceRequest) error {
+	for key, value := range settings.ResourceTags(settings.DomainName) {
+		_, err := ec2.NewTag(ctx, fmt.Sprintf("%s-tag-%s", settings.DomainName, key),
+			&ec2.TagArgs{
+				ResourceId: request.SpotInstanceId,
+				Key:        pulumi.String(key),
+				Value:      pulumi.String(value),
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func GetHostedZone(ctx *pulumi.Context, settings *config.Settings) (*route53.LookupZoneResult, error) {
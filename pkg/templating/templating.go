@@ -0,0 +1,191 @@
+// Package templating renders the userdata and provisioning scripts.
+//
+// Scripts are Go text/templates using {% and %} as delimiters, so they
+// don't clash with bash, executed with the settings as data:
+//
+//	{% if .Settings.Github.Enabled %}
+//	setup_git_repos "___USERNAME___" "___GITHUB_REPOS___"
+//	{% end %}
+//
+// Variables are written ___NAME___ and resolved in a single pass from the
+// values given to New, then from the declarations at the top of the
+// script:
+//
+//	# @var GOLANG_VERSION default=1.19.5
+//	# @var GITLAB_TOKEN required
+//
+// A variable without a value is an error, reported with its script and
+// line along with every other one found.
+package templating
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+)
+
+const (
+	leftDelim  = "{%"
+	rightDelim = "%}"
+)
+
+var (
+	// eg. ___DOMAIN_NAME___ or ___EMAIL__ADDRESS___
+	placeholderPattern = regexp.MustCompile(`___([A-Z0-9][A-Z0-9_]*?)___`)
+	// eg. # @var GOLANG_VERSION default=1.19.5
+	declarationPattern = regexp.MustCompile(`^\s*#\s*@var\s+([A-Z0-9][A-Z0-9_]*)\s*(required|default=(\S*))?\s*$`)
+)
+
+// Problem is a script line that could not be rendered
+type Problem struct {
+	Script  string
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d: %s", p.Script, p.Line, p.Message)
+}
+
+// Problems lists every problem found while rendering, it is returned as an
+// error
+type Problems []Problem
+
+func (problems Problems) Error() string {
+	lines := make([]string, len(problems))
+	for i, problem := range problems {
+		lines[i] = "  " + problem.String()
+	}
+	return fmt.Sprintf("unable to render the scripts (%d problems):\n%s", len(problems), strings.Join(lines, "\n"))
+}
+
+// Collect appends the problems of err to problems, it returns any other
+// error as is
+func (problems *Problems) Collect(err error) error {
+	if found, ok := err.(Problems); ok {
+		*problems = append(*problems, found...)
+		return nil
+	}
+	return err
+}
+
+// Data is what the scripts see as the template dot
+type Data struct {
+	Settings  *config.Settings
+	Variables map[string]string
+}
+
+// Engine renders scripts against a set of settings and variables
+type Engine struct {
+	settings  *config.Settings
+	variables map[string]string
+}
+
+// New returns an engine resolving the variables, named either NAME or
+// ___NAME___
+func New(settings *config.Settings, variables map[string]string) *Engine {
+	engine := &Engine{settings: settings, variables: map[string]string{}}
+	for name, value := range variables {
+		engine.variables[VariableName(name)] = value
+	}
+	return engine
+}
+
+// Variables returns the values of the variables by bare name
+func (engine *Engine) Variables() map[string]string {
+	variables := map[string]string{}
+	for name, value := range engine.variables {
+		variables[name] = value
+	}
+	return variables
+}
+
+// Secret reports whether value is one of the secrets of the settings, eg.
+// the value of ___GITLAB_TOKEN___
+func (engine *Engine) Secret(value string) bool {
+	if engine.settings == nil {
+		return false
+	}
+	for _, secret := range engine.settings.Secrets() {
+		if value == secret {
+			return true
+		}
+	}
+	return false
+}
+
+// VariableName returns the bare name of a variable, eg. GOLANG_VERSION for
+// ___GOLANG_VERSION___
+func VariableName(name string) string {
+	if match := placeholderPattern.FindStringSubmatch(name); match != nil && match[0] == name {
+		return match[1]
+	}
+	return name
+}
+
+// Render renders the script named name, returning Problems when anything
+// could not be resolved
+func (engine *Engine) Render(name string, text string) (string, error) {
+	var problems Problems
+	values := map[string]string{}
+	for name, value := range engine.variables {
+		values[name] = value
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		match := declarationPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		variable, rule, defaultValue := match[1], match[2], match[3]
+		if _, ok := values[variable]; ok {
+			continue
+		}
+		switch {
+		case rule == "required":
+			problems = append(problems, Problem{name, i + 1, fmt.Sprintf("%s is required", variable)})
+		default: // the default value, maybe empty
+			values[variable] = defaultValue
+		}
+	}
+
+	// Placeholders become template calls knowing their line, so that only
+	// the ones in rendered branches need a value
+	for i, line := range lines {
+		lines[i] = placeholderPattern.ReplaceAllString(line, fmt.Sprintf(`%s variable "$1" %d %s`, leftDelim, i+1, rightDelim))
+	}
+	funcs := template.FuncMap{
+		"variable": func(variable string, line int) string {
+			value, ok := values[variable]
+			if !ok {
+				problems = append(problems, Problem{name, line, fmt.Sprintf("___%s___ is not set", variable)})
+			}
+			return value
+		},
+		"quote": Quote,
+		"join":  strings.Join,
+	}
+	tmpl, err := template.New(name).Delims(leftDelim, rightDelim).Funcs(funcs).Option("missingkey=error").
+		Parse(strings.Join(lines, "\n"))
+	if err != nil {
+		return "", err
+	}
+	rendered := strings.Builder{}
+	if err := tmpl.Execute(&rendered, Data{Settings: engine.settings, Variables: values}); err != nil {
+		return "", err
+	}
+	if len(problems) > 0 {
+		sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+		return "", problems
+	}
+	return rendered.String(), nil
+}
+
+// Quote quotes a value as a single bash word
+func Quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
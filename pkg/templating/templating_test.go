@@ -0,0 +1,97 @@
+package templating
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+)
+
+func TestRender(t *testing.T) {
+	settings := &config.Settings{
+		Github: config.ConcurrentVersionsSystemInfo{Enabled: true},
+	}
+	variables := map[string]string{
+		"USERNAME":             "coder",
+		"___GOLANG_VERSION___": "1.20",
+		"EMAIL__ADDRESS":       "me@email.com",
+		"EMAIL":                "wrong",
+	}
+	testCases := []struct {
+		name             string
+		script           string
+		expected         string
+		expectedProblems []string
+	}{
+		{
+			name:     "variables",
+			script:   `install_go "___USERNAME___" "___GOLANG_VERSION___" ___EMAIL__ADDRESS___`,
+			expected: `install_go "coder" "1.20" me@email.com`,
+		},
+		{
+			name:     "declared defaults",
+			script:   "# @var NVM_VERSION default=0.39.3\n# @var GOLANG_VERSION default=1.19\n# @var GOPRIVATE default=\n___NVM_VERSION___ ___GOLANG_VERSION___ '___GOPRIVATE___'",
+			expected: "# @var NVM_VERSION default=0.39.3\n# @var GOLANG_VERSION default=1.19\n# @var GOPRIVATE default=\n0.39.3 1.20 ''",
+		},
+		{
+			name:     "conditionals",
+			script:   "{% if .Settings.Github.Enabled %}github{% end %}{% if .Settings.Gitlab.Enabled %}___GITLAB_TOKEN___{% end %}",
+			expected: "github",
+		},
+		{
+			name:     "functions",
+			script:   `{% quote "it's" %} {% join .Settings.Github.Repositories "," %}`,
+			expected: `'it'\''s' `,
+		},
+		{
+			name:   "unresolved variables are all reported",
+			script: "# @var GITLAB_TOKEN required\necho ___USERNAME___\necho ___GOPRIVATE___ ___NVM_VERSION___",
+			expectedProblems: []string{
+				"setup.sh:1: GITLAB_TOKEN is required",
+				"setup.sh:3: ___GOPRIVATE___ is not set",
+				"setup.sh:3: ___NVM_VERSION___ is not set",
+			},
+		},
+	}
+
+	engine := New(settings, variables)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rendered, err := engine.Render("setup.sh", tc.script)
+			if tc.expectedProblems != nil {
+				var problems Problems
+				if !errors.As(err, &problems) {
+					t.Fatalf("expected Problems, got %v", err)
+				}
+				found := []string{}
+				for _, problem := range problems {
+					found = append(found, problem.String())
+				}
+				if !reflect.DeepEqual(found, tc.expectedProblems) {
+					t.Errorf("expected %v, got %v", tc.expectedProblems, found)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rendered != tc.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tc.expected, rendered)
+			}
+		})
+	}
+}
+
+func TestVariableName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"___GOLANG_VERSION___": "GOLANG_VERSION",
+		"GOLANG_VERSION":       "GOLANG_VERSION",
+		"___EMAIL__ADDRESS___": "EMAIL__ADDRESS",
+		"x___A___":             "x___A___",
+	} {
+		if actual := VariableName(name); actual != expected {
+			t.Errorf("expected %q for %q, got %q", expected, name, actual)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package userdata
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/slim-ai/mob-code-server/pkg/templating"
+	"gopkg.in/yaml.v2"
+)
+
+// The types of steps
+const (
+	TypeShell       = "shell"        // runs the up and down scripts
+	TypeAnsible     = "ansible"      // runs the up playbook on the machine itself
+	TypeCloudConfig = "cloud-config" // user-data only, a cloud-config document
+)
+
+// ansibleDir holds the packages of the ansible steps on the machine
+const ansibleDir = "/opt/mob-server/ansible"
+
+// ansibleVariables is the file of the package holding the values of the
+// placeholders, passed to the playbook as extra vars
+const ansibleVariables = "mob-variables.json"
+
+// ansibleSecretPrefix names the variables of the step environment holding
+// the secret values of the placeholders, eg. MOB_SECRET_GITLAB_TOKEN
+const ansibleSecretPrefix = "MOB_SECRET_"
+
+// ansibleInstall installs ansible, by package manager of the distribution
+var ansibleInstall = map[string]string{
+	"apt": "sudo apt-get update -y && sudo DEBIAN_FRONTEND=noninteractive apt-get install -y ansible",
+	"dnf": "sudo dnf install -y ansible-core",
+}
+
+// ansibleTemplate installs ansible when missing, then runs the playbook of
+// the package locally. The hash of the package makes the step run again
+// when it changes.
+const ansibleTemplate = `set -eu
+# package %s
+if ! command -v ansible-playbook > /dev/null; then
+    %s
+fi
+dir=$(mktemp -d /tmp/mob-ansible.XXXXXX)
+trap 'rm -rf "$dir"' EXIT
+sudo tar -xzf %s -C "$dir"
+sudo chown -R "$(id -un)" "$dir"
+cd "$dir"
+ansible-playbook --inventory localhost, --connection local --extra-vars @%s %s
+`
+
+// validateAnsible checks the playbook of an ansible step
+func (entry *SeqEntry) validateAnsible(fsys fs.FS, directory string) error {
+	if entry.Down != "" {
+		return fmt.Errorf("down can't be set for ansible steps")
+	}
+	if err := entry.validateScripts(fsys, directory); err != nil {
+		return err
+	}
+	b, err := fs.ReadFile(fsys, entry.Up)
+	if err != nil {
+		return fmt.Errorf("up: %w", err)
+	}
+	plays := []map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &plays); err != nil {
+		return fmt.Errorf("up: %s is not a playbook: %w", entry.Up, err)
+	}
+	if len(plays) == 0 {
+		return fmt.Errorf("up: %s has no plays", entry.Up)
+	}
+	return nil
+}
+
+// planAnsible packages the directory of the playbook of an ansible step,
+// uploaded as the file of the step, with the values of the placeholders in
+// lower case (eg. username) in ansibleVariables. The secret values are kept
+// out of the package: the step environment holds them, see
+// ansibleSecretPrefix, and ansibleVariables looks them up. The files aren't
+// rendered, as their jinja templates would clash with the placeholders.
+// Ansible is installed with packageManager, see distro.Distribution.
+func planAnsible(fsys fs.FS, engine *templating.Engine, packageManager string, entry SeqEntry) (Step, error) {
+	step := Step{SeqEntry: entry}
+	install, ok := ansibleInstall[packageManager]
+	if !ok {
+		return step, fmt.Errorf("%s: ansible can't be installed with %s", entry.Name, packageManager)
+	}
+	directory := path.Dir(entry.Up)
+	sources, err := sourceFiles(fsys, directory)
+	if err != nil {
+		return step, err
+	}
+	step.Env = map[string]string{}
+	for name, value := range entry.Env {
+		step.Env[name] = value
+	}
+	variables := map[string]string{}
+	for name, value := range engine.Variables() {
+		if engine.Secret(value) {
+			step.Env[ansibleSecretPrefix+name] = value
+			value = fmt.Sprintf("{{ lookup('env', '%s') }}", ansibleSecretPrefix+name)
+		}
+		variables[strings.ToLower(name)] = value
+	}
+	b, err := json.MarshalIndent(variables, "", "  ")
+	if err != nil {
+		return step, err
+	}
+	contents := map[string][]byte{ansibleVariables: b}
+	for relative, name := range sources {
+		if contents[relative], err = fs.ReadFile(fsys, name); err != nil {
+			return step, err
+		}
+	}
+	pkg, err := packageFiles(contents)
+	if err != nil {
+		return step, err
+	}
+	destination := fmt.Sprintf("%s/%s.tar.gz", ansibleDir, entry.Name)
+	step.Files = []File{{Source: directory, Content: string(pkg), Destination: destination, Owner: "root", Mode: "0600"}}
+	sum := sha256.Sum256(pkg)
+	step.Create = fmt.Sprintf(ansibleTemplate,
+		hex.EncodeToString(sum[:]),
+		install,
+		templating.Quote(destination),
+		ansibleVariables,
+		templating.Quote(path.Base(entry.Up)),
+	)
+	return step, nil
+}
+
+// packageFiles returns the gzipped tarball of the files by path, the same
+// for the same files
+func packageFiles(contents map[string][]byte) ([]byte, error) {
+	names := make([]string, 0, len(contents))
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buffer bytes.Buffer
+	compressed := gzip.NewWriter(&buffer)
+	archive := tar.NewWriter(compressed)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(contents[name]))}
+		if err := archive.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := archive.Write(contents[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	if err := compressed.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,94 @@
+package userdata
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+	"github.com/slim-ai/mob-code-server/pkg/scriptcheck"
+	"github.com/slim-ai/mob-code-server/pkg/templating"
+)
+
+func TestPlanAnsible(t *testing.T) {
+	fsys := sequenceFS("sequence:\n  - up: ansible/developer.yml\n    type: ansible\n")
+	fsys["ansible/developer.yml"] = &fstest.MapFile{Data: []byte("- hosts: localhost\n  roles: [editor]\n")}
+	fsys["ansible/roles/editor/tasks/main.yml"] = &fstest.MapFile{Data: []byte("- apt: {name: vim}\n  become: true\n")}
+	entries, err := getScripts(fsys, ".")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	settings := &config.Settings{}
+	settings.Gitlab.Token = "glpat-secret"
+	engine := templating.New(settings, map[string]string{"USERNAME": "coder", "GITLAB_TOKEN": "glpat-secret"})
+	step, err := planAnsible(fsys, engine, "apt", entries[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if step.Name != "developer.yml" || step.CreateName() != "developer.yml.sh" || len(step.Files) != 1 {
+		t.Fatalf("unexpected step %+v", step)
+	}
+	file := step.Files[0]
+	if file.Destination != "/opt/mob-server/ansible/developer.yml.tar.gz" || file.Owner != "root" || file.Mode != "0600" {
+		t.Errorf("unexpected file %+v", file)
+	}
+	if !strings.Contains(step.Create, "--extra-vars @mob-variables.json 'developer.yml'") || !strings.Contains(step.Create, "apt-get install -y ansible\n") {
+		t.Errorf("unexpected command:\n%s", step.Create)
+	}
+	if problems := scriptcheck.Check(step.CreateName(), step.Create); len(problems) > 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+
+	compressed, err := gzip.NewReader(bytes.NewReader([]byte(file.Content)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	archive := tar.NewReader(compressed)
+	contents := map[string]string{}
+	for {
+		header, err := archive.Next()
+		if err != nil {
+			break
+		}
+		b, _ := ioutil.ReadAll(archive)
+		contents[header.Name] = string(b)
+	}
+	expected := map[string]string{
+		"developer.yml":               "- hosts: localhost\n  roles: [editor]\n",
+		"roles/editor/tasks/main.yml": "- apt: {name: vim}\n  become: true\n",
+		"mob-variables.json":          "{\n  \"gitlab_token\": \"{{ lookup('env', 'MOB_SECRET_GITLAB_TOKEN') }}\",\n  \"username\": \"coder\"\n}",
+	}
+	if !reflect.DeepEqual(contents, expected) {
+		t.Errorf("expected %v, got %v", expected, contents)
+	}
+	// the token reaches the machine through the step environment only
+	for name, content := range contents {
+		if strings.Contains(content, "glpat-secret") {
+			t.Errorf("expected the token to be kept out of the package, found in %s", name)
+		}
+	}
+	if step.Env["MOB_SECRET_GITLAB_TOKEN"] != "glpat-secret" || !strings.Contains(step.Command(), "MOB_SECRET_GITLAB_TOKEN=glpat-secret") {
+		t.Errorf("expected the token in the step environment, got %v", step.Env)
+	}
+
+	// the same files give the same package, and the same command
+	again, err := planAnsible(fsys, engine, "apt", entries[0])
+	if err != nil || again.Create != step.Create || again.Files[0].Content != file.Content {
+		t.Errorf("expected the same step, got %v", err)
+	}
+	if dnf, _ := planAnsible(fsys, engine, "dnf", entries[0]); !strings.Contains(dnf.Create, "dnf install -y ansible-core\n") {
+		t.Errorf("expected ansible to be installed with dnf:\n%s", dnf.Create)
+	}
+	if _, err := planAnsible(fsys, engine, "pacman", entries[0]); err == nil {
+		t.Errorf("expected an error for an unknown package manager")
+	}
+	fsys["ansible/roles/editor/tasks/main.yml"] = &fstest.MapFile{Data: []byte("- apt: {name: emacs}\n")}
+	if changed, _ := planAnsible(fsys, engine, "apt", entries[0]); changed.Create == step.Create {
+		t.Errorf("expected a changed role to change the command")
+	}
+}
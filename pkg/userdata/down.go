@@ -0,0 +1,77 @@
+package userdata
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/slim-ai/mob-code-server/pkg/config"
+	"github.com/slim-ai/mob-code-server/pkg/templating"
+)
+
+// downTemplate runs the down command of a step from the local machine over
+// ssh, for its output to be appended to the log of the step with the
+// secrets masked. The key, address, command and mask come from the
+// environment, encrypted in the stack state.
+const downTemplate = `set -o pipefail
+key=$(mktemp)
+trap 'rm -f "$key"' EXIT
+printf '%%s\n' "$MOB_SSH_KEY" > "$key"
+mkdir -p %s
+%sssh -i "$key" -o BatchMode=yes -o ConnectTimeout=30 -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null \
+    "$MOB_SSH_ADDRESS" "$MOB_DOWN" 2>&1 | sed "$MOB_MASK" | tee -a %s
+`
+
+// maskScript returns the sed script replacing the secrets by
+// config.SecretMask, line by line for the ones spanning several lines (eg.
+// a private key)
+func maskScript(secrets []string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `/`, `\/`, `.`, `\.`, `*`, `\*`, `[`, `\[`, `]`, `\]`, `^`, `\^`, `$`, `\$`, `&`, `\&`)
+	commands := []string{}
+	for _, secret := range secrets {
+		for _, line := range strings.Split(secret, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				commands = append(commands, fmt.Sprintf("s/%s/%s/g", escape.Replace(line), escape.Replace(config.SecretMask)))
+			}
+		}
+	}
+	return strings.Join(commands, "\n")
+}
+
+// downCommand returns the local command running the down script of step on
+// the machine at host when pulumi deletes it, after the steps waiting for it
+// and before the ones it waits for. A step without down script only has its
+// mark removed, without logging.
+func downCommand(ctx *pulumi.Context, settings *config.Settings, host pulumi.StringOutput, step Step,
+	triggers pulumi.ArrayInput, dependsOns []pulumi.Resource) (*local.Command, error) {
+	address := host.ApplyT(func(host string) string {
+		return settings.MachineInfo.LoginUser + "@" + host
+	}).(pulumi.StringOutput)
+	environment := pulumi.ToSecret(pulumi.StringMap{
+		"MOB_SSH_KEY":     pulumi.String(settings.MachineInfo.Credentials.Private),
+		"MOB_SSH_ADDRESS": address,
+		"MOB_DOWN":        pulumi.String(step.DeleteCommand()),
+		"MOB_MASK":        pulumi.String(maskScript(settings.Secrets())),
+	}).(pulumi.StringMapOutput)
+	return local.NewCommand(ctx, step.Name+"-down", &local.CommandArgs{
+		Create:      pulumi.StringPtr("true"),
+		Delete:      pulumi.StringPtr(downScript(ctx.Stack(), step)),
+		Environment: environment,
+		Interpreter: pulumi.StringArray{pulumi.String("/bin/bash"), pulumi.String("-c")},
+		Triggers:    triggers,
+	}, pulumi.DependsOn(dependsOns), deleteBeforeReplace)
+}
+
+// downScript returns the local script running the down command of step, see
+// downTemplate
+func downScript(stack string, step Step) string {
+	log := "/dev/null"
+	header := ""
+	if step.Delete != "" {
+		log = filepath.Join(LogsDir(stack), step.Name+".log")
+		header = fmt.Sprintf("echo \"--- down $(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ)\" >> %s\n", templating.Quote(log))
+	}
+	return fmt.Sprintf(downTemplate, templating.Quote(LogsDir(stack)), header, templating.Quote(log))
+}
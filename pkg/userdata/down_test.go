@@ -0,0 +1,78 @@
+package userdata
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDownScript(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	// runs the command it is given locally, as the machine would
+	bin := t.TempDir()
+	fake := "#!/usr/bin/env bash\nbash -c \"${@: -1}\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+	project := t.TempDir()
+	dir := filepath.Join(project, "cmd")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	home := t.TempDir()
+	step := Step{SeqEntry: SeqEntry{Name: "git.sh"}, Delete: "echo removing the key glpat-secret"}
+	run := func(step Step) error {
+		cmd := exec.Command("bash", "-c", downScript("dev", step))
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"),
+			"HOME="+home,
+			"MOB_SSH_KEY=key",
+			"MOB_SSH_ADDRESS=ubuntu@192.0.2.1",
+			"MOB_DOWN="+step.DeleteCommand(),
+			"MOB_MASK="+maskScript([]string{"glpat-secret", "-----BEGIN KEY-----\nabc.*def\n-----END KEY-----"}),
+		)
+		return cmd.Run()
+	}
+	marker := filepath.Join(home, ".mob-server", "steps", step.Name)
+	if err := exec.Command("bash", "-c", "HOME="+home+"; "+step.MarkCommand()).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(step); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(project, "logs", "dev", "git.sh.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := regexp.MustCompile(`^--- down \S+Z\nremoving the key \*\*\*\*\*\*\*\*\nmob-step: status=0 `)
+	if !expected.Match(b) {
+		t.Errorf("unexpected log:\n%s", b)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("expected the mark of the step to be removed, got %v", err)
+	}
+
+	// the failure of the down script fails the delete
+	step.Delete = "exit 3"
+	if err := run(step); err == nil {
+		t.Errorf("expected the failed down script to fail")
+	}
+}
+
+func TestMaskScript(t *testing.T) {
+	cmd := exec.Command("sed", maskScript([]string{"a.b/c&d", "line one\nline two"}))
+	cmd.Stdin = strings.NewReader("x a.b/c&d axbyc\nline one\nline two!\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "x ******** axbyc\n********\n********!\n" {
+		t.Errorf("unexpected output %q", output)
+	}
+}
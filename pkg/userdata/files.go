@@ -0,0 +1,242 @@
+package userdata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/remote"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/slim-ai/mob-code-server/pkg/templating"
+)
+
+// FileEntry is a file of a files step of sequence.yml
+type FileEntry struct {
+	Source      string `yaml:"source"`      // file or directory, relative to sequence.yml
+	Content     string `yaml:"content"`     // inline template, instead of source
+	Template    bool   `yaml:"template"`    // render the source files like the scripts
+	Destination string `yaml:"destination"` // absolute path on the machine, the directory of a source directory
+	Owner       string `yaml:"owner"`       // user or user:group, defaults to root
+	Mode        string `yaml:"mode"`        // octal, defaults to 0644
+}
+
+// File is a file rendered for the settings, uploaded to the machine
+type File struct {
+	Source      string // in the scripts tree, empty for inline content
+	Content     string
+	Destination string
+	Owner       string
+	Group       string
+	Mode        string
+}
+
+var (
+	// a linux user, or a placeholder like ___USERNAME___, with an optional group
+	ownerPattern = regexp.MustCompile(`^([a-z_][a-z0-9_-]{0,31}|___[A-Z0-9][A-Z0-9_]*___)(:([a-z_][a-z0-9_-]{0,31}|___[A-Z0-9][A-Z0-9_]*___))?$`)
+	modePattern  = regexp.MustCompile(`^0?[0-7]{3}$`)
+)
+
+// validateFiles checks and resolves the files of a files step
+func (entry *SeqEntry) validateFiles(fsys fs.FS, directory string) error {
+	if entry.Up != "" || entry.Down != "" || entry.RunAs != "" || len(entry.Env) > 0 || len(entry.Requires) > 0 {
+		return fmt.Errorf("files can't be set with up, down, run_as, env or requires")
+	}
+	if entry.Name == "" {
+		return fmt.Errorf("name must be set for files")
+	}
+	for i := range entry.Files {
+		file := &entry.Files[i]
+		field := fmt.Sprintf("files[%d]", i)
+		if (file.Source == "") == (file.Content == "") {
+			return fmt.Errorf("%s: either source or content must be set", field)
+		}
+		if file.Source != "" {
+			file.Source = path.Join(directory, file.Source)
+			if _, err := fs.Stat(fsys, file.Source); err != nil {
+				return fmt.Errorf("%s: source: %w", field, err)
+			}
+		}
+		if file.Destination == "" {
+			return fmt.Errorf("%s: destination must be set", field)
+		}
+		if file.Owner == "" {
+			file.Owner = "root"
+		}
+		if !ownerPattern.MatchString(file.Owner) {
+			return fmt.Errorf("%s: owner must be a user or user:group, got %q", field, file.Owner)
+		}
+		if file.Mode == "" {
+			file.Mode = "0644"
+		}
+		if !modePattern.MatchString(file.Mode) {
+			return fmt.Errorf("%s: mode must be octal (eg. 0600), got %q", field, file.Mode)
+		}
+	}
+	return nil
+}
+
+// planFiles renders the files of a step, one per file of the source
+// directories
+func planFiles(fsys fs.FS, engine *templating.Engine, entry SeqEntry) ([]File, error) {
+	var problems templating.Problems
+	files := []File{}
+	source := fmt.Sprintf("sequence.yml (%s)", entry.Name)
+	for _, fileEntry := range entry.Files {
+		var err error
+		file := File{}
+		if file.Destination, err = engine.Render(source, fileEntry.Destination); problems.Collect(err) != nil {
+			return nil, err
+		}
+		owner, err := engine.Render(source, fileEntry.Owner)
+		if problems.Collect(err) != nil {
+			return nil, err
+		}
+		file.Owner, file.Group = owner, ""
+		if i := strings.Index(owner, ":"); i >= 0 {
+			file.Owner, file.Group = owner[:i], owner[i+1:]
+		}
+		file.Mode = fileEntry.Mode
+		if !path.IsAbs(file.Destination) || path.Clean(file.Destination) != file.Destination {
+			return nil, fmt.Errorf("%s: destination must be a clean absolute path, got %q", source, file.Destination)
+		}
+		if fileEntry.Content != "" {
+			if file.Content, err = engine.Render(source, fileEntry.Content); problems.Collect(err) != nil {
+				return nil, err
+			}
+			files = append(files, file)
+			continue
+		}
+		sources, err := sourceFiles(fsys, fileEntry.Source)
+		if err != nil {
+			return nil, err
+		}
+		for relative, name := range sources {
+			sourceFile := file
+			sourceFile.Destination = path.Join(file.Destination, relative)
+			sourceFile.Source = name
+			if fileEntry.Template {
+				sourceFile.Content, err = renderFile(fsys, engine, name)
+			} else {
+				var content []byte
+				content, err = fs.ReadFile(fsys, name)
+				sourceFile.Content = string(content)
+			}
+			if problems.Collect(err) != nil {
+				return nil, err
+			}
+			files = append(files, sourceFile)
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Destination < files[j].Destination })
+	return files, nil
+}
+
+// sourceFiles returns the regular files under source by path relative to
+// it, or source itself by an empty path for a file
+func sourceFiles(fsys fs.FS, source string) (map[string]string, error) {
+	files := map[string]string{}
+	err := fs.WalkDir(fsys, source, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		files[strings.TrimPrefix(strings.TrimPrefix(name, source), "/")] = name
+		return nil
+	})
+	return files, err
+}
+
+// installTemplate moves an uploaded file into place, creating the missing
+// directories with the same owner
+const installTemplate = `set -eu
+owner=%s
+group=%s
+[ -n "$group" ] || group=$(id -gn "$owner")
+missing=()
+dir=$(dirname %s)
+while [ ! -d "$dir" ]; do
+    missing=("$dir" "${missing[@]}")
+    dir=$(dirname "$dir")
+done
+for dir in "${missing[@]}"; do
+    sudo install -d -o "$owner" -g "$group" "$dir"
+done
+sudo install -o "$owner" -g "$group" -m %s %s %s
+rm -f %s
+`
+
+// installCommand returns the command moving the file uploaded to upload
+// into place
+func (file File) installCommand(upload string) string {
+	destination := templating.Quote(file.Destination)
+	return fmt.Sprintf(installTemplate,
+		templating.Quote(file.Owner),
+		templating.Quote(file.Group),
+		destination,
+		file.Mode, templating.Quote(upload), destination,
+		templating.Quote(upload),
+	)
+}
+
+// hash identifies the file content and where it goes
+func (file File) hash() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{file.Content, file.Destination, file.Owner, file.Group, file.Mode}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// uploadFiles copies the files of a step to the machine once after
+// resolves, then moves them into place. The content is written to a local
+// staging directory first, as the copy reads local files.
+func uploadFiles(ctx *pulumi.Context, connection remote.ConnectionArgs, step Step, forced string,
+	after pulumi.StringOutput, dependsOns []pulumi.Resource) ([]pulumi.Resource, pulumi.StringOutput, error) {
+	fail := func(err error) ([]pulumi.Resource, pulumi.StringOutput, error) {
+		return nil, pulumi.StringOutput{}, err
+	}
+	staging := filepath.Join(os.TempDir(), "mob-server-"+ctx.Stack())
+	if err := os.MkdirAll(staging, 0700); err != nil {
+		return fail(err)
+	}
+	for i, file := range step.Files {
+		hash := file.hash()
+		local := filepath.Join(staging, hash)
+		if err := ioutil.WriteFile(local, []byte(file.Content), 0600); err != nil {
+			return fail(err)
+		}
+		name := fmt.Sprintf("%s-%d", step.Name, i)
+		upload := "/tmp/mob-file-" + hash
+		triggers := pulumi.Array{pulumi.String(hash)}
+		if forced != "" {
+			triggers = append(triggers, pulumi.String(forced))
+		}
+		copied, err := remote.NewCopyFile(ctx, name+"-copy", &remote.CopyFileArgs{
+			Connection: connection,
+			LocalPath:  pulumi.String(local),
+			RemotePath: after.ApplyT(func(string) string { return upload }).(pulumi.StringOutput),
+			Triggers:   triggers,
+		}, pulumi.DependsOn(dependsOns), deleteBeforeReplace)
+		if err != nil {
+			return fail(err)
+		}
+		installed, err := remote.NewCommand(ctx, name, &remote.CommandArgs{
+			Connection: connection,
+			Create:     pulumi.StringPtr(file.installCommand(upload)),
+			Triggers:   triggers,
+		}, pulumi.DependsOn(append(dependsOns, copied)), deleteBeforeReplace)
+		if err != nil {
+			return fail(err)
+		}
+		dependsOns = append(dependsOns, installed)
+		after = installed.Stdout.ApplyT(func(string) string { return "" }).(pulumi.StringOutput)
+	}
+	return dependsOns, after, nil
+}
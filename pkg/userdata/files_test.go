@@ -0,0 +1,70 @@
+package userdata
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+	"github.com/slim-ai/mob-code-server/pkg/templating"
+)
+
+func TestPlanFiles(t *testing.T) {
+	fsys := sequenceFS(`sequence:
+  - name: dotfiles
+    files:
+      - source: dotfiles
+        destination: /home/___USERNAME___
+        owner: ___USERNAME___
+        template: true
+      - content: "registry=___NPM_REGISTRY___\n"
+        destination: /home/___USERNAME___/.npmrc
+        owner: ___USERNAME___:staff
+        mode: "0600"
+      - source: setup.sh
+        destination: /usr/local/bin/setup
+        mode: "0755"
+`)
+	fsys["dotfiles/.bashrc"] = &fstest.MapFile{Data: []byte("export EDITOR=vim\n")}
+	fsys["dotfiles/.config/git.conf"] = &fstest.MapFile{Data: []byte("[user]\n    email = ___EMAIL__ADDRESS___\n")}
+	entries, err := getScripts(fsys, ".")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	engine := templating.New(&config.Settings{}, map[string]string{
+		"USERNAME":       "coder",
+		"EMAIL__ADDRESS": "me@email.com",
+		"NPM_REGISTRY":   "https://npm.example.com",
+	})
+	files, err := planFiles(fsys, engine, entries[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []File{
+		{Source: "dotfiles/.bashrc", Content: "export EDITOR=vim\n", Destination: "/home/coder/.bashrc", Owner: "coder", Mode: "0644"},
+		{Source: "dotfiles/.config/git.conf", Content: "[user]\n    email = me@email.com\n", Destination: "/home/coder/.config/git.conf", Owner: "coder", Mode: "0644"},
+		{Content: "registry=https://npm.example.com\n", Destination: "/home/coder/.npmrc", Owner: "coder", Group: "staff", Mode: "0600"},
+		{Source: "setup.sh", Content: "echo setup\n", Destination: "/usr/local/bin/setup", Owner: "root", Mode: "0755"},
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("expected %d files, got %+v", len(expected), files)
+	}
+	for i := range expected {
+		if files[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], files[i])
+		}
+	}
+
+	if _, err := exec.LookPath("bash"); err == nil {
+		command := files[0].installCommand("/tmp/mob-file-0")
+		if output, err := exec.Command("bash", "-n", "-c", command).CombinedOutput(); err != nil {
+			t.Errorf("unexpected error: %v\n%s", err, output)
+		}
+	}
+
+	entries[0].Files[1].Destination = "___USERNAME___/.npmrc"
+	if _, err := planFiles(fsys, engine, entries[0]); err == nil || !strings.Contains(err.Error(), "must be a clean absolute path") {
+		t.Errorf("expected a relative destination to fail, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package userdata
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/slim-ai/mob-code-server/pkg/components"
+)
+
+// orderEntries resolves the DependsOn of the provisioning entries to entry
+// names. An entry waits for the previous one, or for every entry of the
+// previous group, unless it lists the steps and groups it waits for in
+// after. The entries of a group wait for what the first one waits for.
+func orderEntries(entries []SeqEntry) error {
+	groups := map[string][]string{}
+	names := map[string]bool{}
+	for _, entry := range entries {
+		names[entry.Name] = true
+	}
+	for i, entry := range entries {
+		if entry.Group == "" {
+			continue
+		}
+		if names[entry.Group] {
+			return fmt.Errorf("sequence[%d]: group %q is also the name of a step", i, entry.Group)
+		}
+		if members := groups[entry.Group]; len(members) > 0 && entries[i-1].Group != entry.Group {
+			return fmt.Errorf("sequence[%d]: the entries of group %q must follow each other", i, entry.Group)
+		}
+		groups[entry.Group] = append(groups[entry.Group], entry.Name)
+	}
+	previous := []string{}
+	for i := range entries {
+		entry := &entries[i]
+		switch {
+		case len(entry.After) > 0:
+			entry.DependsOn = []string{}
+			for _, name := range entry.After {
+				if members, ok := groups[name]; ok {
+					entry.DependsOn = append(entry.DependsOn, members...)
+				} else if names[name] {
+					entry.DependsOn = append(entry.DependsOn, name)
+				} else {
+					return fmt.Errorf("sequence[%d]: after: no step or group is named %q", i, name)
+				}
+			}
+			if contains(entry.DependsOn, entry.Name) {
+				return fmt.Errorf("sequence[%d]: after: %s can't wait for itself", i, entry.Name)
+			}
+		case i > 0 && entry.Group != "" && entries[i-1].Group == entry.Group:
+			entry.DependsOn = entries[i-1].DependsOn
+		default:
+			entry.DependsOn = previous
+		}
+		previous = []string{entry.Name}
+		if entry.Group != "" {
+			previous = groups[entry.Group]
+		}
+	}
+	return checkCycles(entries)
+}
+
+// checkCycles reports the first cycle of the after dependencies
+func checkCycles(entries []SeqEntry) error {
+	dependsOn := map[string][]string{}
+	for _, entry := range entries {
+		dependsOn[entry.Name] = entry.DependsOn
+	}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			for i := range path {
+				if path[i] == name {
+					return fmt.Errorf("after: %s form a cycle", strings.Join(append(path[i:], name), " -> "))
+				}
+			}
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, dependency := range dependsOn[name] {
+			if err := visit(dependency, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, entry := range entries {
+		if err := visit(entry.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runningDependencies returns the running entries an entry waits for, in
+// place of the ones skipped by their condition
+func runningDependencies(entry SeqEntry, all map[string]SeqEntry, running map[string]bool) []string {
+	dependencies := []string{}
+	for _, name := range entry.DependsOn {
+		if running[name] {
+			dependencies = appendMissing(dependencies, name)
+			continue
+		}
+		for _, dependency := range runningDependencies(all[name], all, running) {
+			dependencies = appendMissing(dependencies, dependency)
+		}
+	}
+	return dependencies
+}
+
+// componentDependencies returns what each component step waits for: what
+// the components entry waits for, then the previous component, or only
+// the components it requires when the entry is parallel
+func componentDependencies(entry SeqEntry, selections []components.Selection) map[string][]string {
+	dependencies := map[string][]string{}
+	previous := ""
+	for _, selection := range selections {
+		name := "component-" + selection.Name
+		dependencies[name] = append([]string{}, entry.DependsOn...)
+		if !entry.Parallel && previous != "" {
+			dependencies[name] = append(dependencies[name], previous)
+		}
+		if entry.Parallel {
+			for _, requirement := range selection.Requires {
+				for _, selected := range selections {
+					if selected.Name == requirement {
+						dependencies[name] = append(dependencies[name], "component-"+requirement)
+					}
+				}
+			}
+		}
+		previous = name
+	}
+	return dependencies
+}
+
+// sortSteps orders the steps so that each one follows the ones it waits
+// for, keeping the sequence order otherwise
+func sortSteps(steps []Step) []Step {
+	sorted := make([]Step, 0, len(steps))
+	for _, i := range topologicalOrder(len(steps), func(i int) SeqEntry { return steps[i].SeqEntry }) {
+		sorted = append(sorted, steps[i])
+	}
+	return sorted
+}
+
+// sortEntries is sortSteps for the entries of a sequence
+func sortEntries(entries []SeqEntry) []SeqEntry {
+	sorted := make([]SeqEntry, 0, len(entries))
+	for _, i := range topologicalOrder(len(entries), func(i int) SeqEntry { return entries[i] }) {
+		sorted = append(sorted, entries[i])
+	}
+	return sorted
+}
+
+// topologicalOrder returns the indexes of count entries, each one after
+// the ones of its DependsOn, the earliest first when several are ready
+func topologicalOrder(count int, entry func(i int) SeqEntry) []int {
+	order := []int{}
+	done := map[string]bool{}
+	for progress := true; progress; {
+		progress = false
+		for i := 0; i < count; i++ {
+			if done[entry(i).Name] {
+				continue
+			}
+			ready := true
+			for _, dependency := range entry(i).DependsOn {
+				ready = ready && done[dependency]
+			}
+			if ready {
+				order = append(order, i)
+				done[entry(i).Name] = true
+				progress = true
+				break
+			}
+		}
+	}
+	return order
+}
+
+// waitedFor returns the steps a step waits for, directly or not
+func waitedFor(steps []Step, name string) map[string]bool {
+	dependsOn := map[string][]string{}
+	for _, step := range steps {
+		dependsOn[step.Name] = step.DependsOn
+	}
+	waited := map[string]bool{}
+	var visit func(name string)
+	visit = func(name string) {
+		for _, dependency := range dependsOn[name] {
+			if !waited[dependency] {
+				waited[dependency] = true
+				visit(dependency)
+			}
+		}
+	}
+	visit(name)
+	return waited
+}
+
+func appendMissing(values []string, value string) []string {
+	if contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
+// Graph describes the order of the steps, a line per step with the ones it
+// waits for
+func Graph(steps []Step) string {
+	lines := []string{}
+	for _, step := range steps {
+		if len(step.DependsOn) == 0 {
+			lines = append(lines, step.Name)
+		} else {
+			lines = append(lines, fmt.Sprintf("%s <- %s", step.Name, strings.Join(step.DependsOn, ", ")))
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,159 @@
+package userdata
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/slim-ai/mob-code-server/pkg/components"
+)
+
+func TestOrderEntries(t *testing.T) {
+	testCases := []struct {
+		name        string
+		entries     []SeqEntry
+		expected    map[string][]string
+		expectedErr string
+	}{
+		{
+			name:     "in order by default",
+			entries:  []SeqEntry{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+			expected: map[string][]string{"a": {}, "b": {"a"}, "c": {"b"}},
+		},
+		{
+			name: "groups run concurrently",
+			entries: []SeqEntry{
+				{Name: "a"}, {Name: "b", Group: "tools"}, {Name: "c", Group: "tools"}, {Name: "d"},
+			},
+			expected: map[string][]string{"a": {}, "b": {"a"}, "c": {"a"}, "d": {"b", "c"}},
+		},
+		{
+			name: "after steps and groups",
+			entries: []SeqEntry{
+				{Name: "a"}, {Name: "b", Group: "tools"}, {Name: "c", Group: "tools"},
+				{Name: "d", After: []string{"a"}}, {Name: "e", After: []string{"tools", "d"}},
+			},
+			expected: map[string][]string{"a": {}, "b": {"a"}, "c": {"a"}, "d": {"a"}, "e": {"b", "c", "d"}},
+		},
+		{
+			name:        "unknown step",
+			entries:     []SeqEntry{{Name: "a"}, {Name: "b", After: []string{"setup"}}},
+			expectedErr: `sequence[1]: after: no step or group is named "setup"`,
+		},
+		{
+			name:        "itself",
+			entries:     []SeqEntry{{Name: "a", Group: "tools"}, {Name: "b", Group: "tools", After: []string{"tools"}}},
+			expectedErr: "sequence[1]: after: b can't wait for itself",
+		},
+		{
+			name:        "cycle",
+			entries:     []SeqEntry{{Name: "a", After: []string{"c"}}, {Name: "b"}, {Name: "c"}},
+			expectedErr: "after: a -> c -> b -> a form a cycle",
+		},
+		{
+			name:        "scattered group",
+			entries:     []SeqEntry{{Name: "a", Group: "tools"}, {Name: "b"}, {Name: "c", Group: "tools"}},
+			expectedErr: `sequence[2]: the entries of group "tools" must follow each other`,
+		},
+		{
+			name:        "group named like a step",
+			entries:     []SeqEntry{{Name: "a"}, {Name: "b", Group: "a"}},
+			expectedErr: `sequence[1]: group "a" is also the name of a step`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := orderEntries(tc.entries)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected an error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			dependsOn := map[string][]string{}
+			for _, entry := range tc.entries {
+				dependsOn[entry.Name] = entry.DependsOn
+			}
+			if !reflect.DeepEqual(dependsOn, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, dependsOn)
+			}
+		})
+	}
+}
+
+func TestRunningDependencies(t *testing.T) {
+	entries := []SeqEntry{{Name: "a"}, {Name: "b", Group: "tools"}, {Name: "c", Group: "tools"}, {Name: "d"}, {Name: "e"}}
+	if err := orderEntries(entries); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	all := map[string]SeqEntry{}
+	for _, entry := range entries {
+		all[entry.Name] = entry
+	}
+	// the skipped steps are replaced by the ones they wait for
+	running := map[string]bool{"a": true, "b": false, "c": true, "d": false, "e": true}
+	if dependencies := runningDependencies(all["e"], all, running); !reflect.DeepEqual(dependencies, []string{"a", "c"}) {
+		t.Errorf("unexpected dependencies %v", dependencies)
+	}
+}
+
+func TestComponentDependencies(t *testing.T) {
+	selections := []components.Selection{
+		{Component: components.Component{Name: "go"}},
+		{Component: components.Component{Name: "aws-cli"}},
+		{Component: components.Component{Name: "regctl", Requires: []string{"go"}}},
+	}
+	entry := SeqEntry{Name: "components", DependsOn: []string{"setup.sh"}}
+	expected := map[string][]string{
+		"component-go":      {"setup.sh"},
+		"component-aws-cli": {"setup.sh", "component-go"},
+		"component-regctl":  {"setup.sh", "component-aws-cli"},
+	}
+	if dependencies := componentDependencies(entry, selections); !reflect.DeepEqual(dependencies, expected) {
+		t.Errorf("expected %v, got %v", expected, dependencies)
+	}
+	entry.Parallel = true
+	expected = map[string][]string{
+		"component-go":      {"setup.sh"},
+		"component-aws-cli": {"setup.sh"},
+		"component-regctl":  {"setup.sh", "component-go"},
+	}
+	if dependencies := componentDependencies(entry, selections); !reflect.DeepEqual(dependencies, expected) {
+		t.Errorf("expected %v, got %v", expected, dependencies)
+	}
+}
+
+func TestSortSteps(t *testing.T) {
+	steps := []Step{
+		{SeqEntry: SeqEntry{Name: "a", DependsOn: []string{"c"}}},
+		{SeqEntry: SeqEntry{Name: "b"}},
+		{SeqEntry: SeqEntry{Name: "c", DependsOn: []string{"b"}}},
+	}
+	names := []string{}
+	for _, step := range sortSteps(steps) {
+		names = append(names, step.Name)
+	}
+	if strings.Join(names, ",") != "b,c,a" {
+		t.Errorf("unexpected order %v", names)
+	}
+	if graph := Graph(sortSteps(steps)); graph != "b\nc <- b\na <- c\n" {
+		t.Errorf("unexpected graph %q", graph)
+	}
+}
+
+func TestWaitedFor(t *testing.T) {
+	steps := []Step{
+		{SeqEntry: SeqEntry{Name: "setup.sh"}},
+		{SeqEntry: SeqEntry{Name: "component-go", DependsOn: []string{"setup.sh"}}},
+		{SeqEntry: SeqEntry{Name: "component-nvm", DependsOn: []string{"setup.sh"}}},
+		{SeqEntry: SeqEntry{Name: "git.sh", DependsOn: []string{"component-go"}}},
+	}
+	expected := map[string]bool{"setup.sh": true, "component-go": true}
+	if waited := waitedFor(steps, "git.sh"); !reflect.DeepEqual(waited, expected) {
+		t.Errorf("expected %v, got %v", expected, waited)
+	}
+}
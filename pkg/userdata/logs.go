@@ -0,0 +1,225 @@
+package userdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+)
+
+// statusPrefix starts the last line printed by the step wrapper
+const statusPrefix = "mob-step: "
+
+// outputPrefix starts the lines of the outputs of a step, name=value
+const outputPrefix = "mob-output: "
+
+// lastLines is the number of output lines kept in the summary
+const lastLines = 20
+
+// The status of a step in the summary
+const (
+	StatusOk      = "ok"
+	StatusFailed  = "failed"
+	StatusNotRun  = "not run"
+	StatusSkipped = "skipped" // already completed on the machine, or before settings.resume_from
+)
+
+var (
+	statusPattern = regexp.MustCompile(`(?m)^` + statusPrefix + `status=(\d+) attempts=(\d+) seconds=(\d+) started=(\d+)\n?$`)
+	outputPattern = regexp.MustCompile(`(?m)^` + outputPrefix + `(.*)\n?`)
+)
+
+// StepResult is how a provisioning step went on its last run
+type StepResult struct {
+	Step       string   `json:"step"`
+	Status     string   `json:"status"` // ok, failed, not run or skipped
+	ExitStatus int      `json:"exit_status"`
+	Attempts   int      `json:"attempts,omitempty"`
+	Seconds    int      `json:"duration_seconds"`
+	Started    string   `json:"started,omitempty"`
+	LastLines  []string `json:"last_lines,omitempty"`
+	Log        string   `json:"log,omitempty"`
+}
+
+// Summary is written to summary.json next to the step logs
+type Summary struct {
+	Stack   string       `json:"stack"`
+	Updated string       `json:"updated"`
+	Steps   []StepResult `json:"steps"`
+}
+
+// LogsDir returns the directory of the step logs of a stack, relative to
+// project/cmd
+func LogsDir(stack string) string {
+	return filepath.Join("..", "logs", stack)
+}
+
+// parseResult reads the status line at the end of the output of a step,
+// returning the output without it
+func parseResult(name string, stdout string) (StepResult, string) {
+	result := StepResult{Step: name, Status: StatusFailed, ExitStatus: -1}
+	match := statusPattern.FindStringSubmatchIndex(stdout)
+	if match == nil {
+		// eg. the wrapper itself failed
+		return result, stdout
+	}
+	number := func(i int) int {
+		n, _ := strconv.Atoi(stdout[match[2*i]:match[2*i+1]])
+		return n
+	}
+	result.ExitStatus = number(1)
+	result.Attempts = number(2)
+	result.Seconds = number(3)
+	result.Started = time.Unix(int64(number(4)), 0).UTC().Format(time.RFC3339)
+	if result.ExitStatus == 0 {
+		result.Status = StatusOk
+		if result.Attempts == 0 {
+			result.Status = StatusSkipped
+		}
+	}
+	return result, stdout[:match[0]] + stdout[match[1]:]
+}
+
+// parseOutputs reads the outputs reported by a step, the last value of each
+// name, returning the output of the step without them
+func parseOutputs(stdout string) (map[string]string, string) {
+	outputs := map[string]string{}
+	for _, match := range outputPattern.FindAllStringSubmatch(stdout, -1) {
+		if i := strings.Index(match[1], "="); i > 0 {
+			outputs[match[1][:i]] = match[1][i+1:]
+		}
+	}
+	return outputs, outputPattern.ReplaceAllString(stdout, "")
+}
+
+// parseFailures reads the output of FailuresCommand, returning the time of
+// the last failure of each step
+func parseFailures(output string) map[string]string {
+	failures := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		if i := strings.Index(line, ":"); i > 0 {
+			failures[line[:i]] = line[i+1:]
+		}
+	}
+	return failures
+}
+
+// provisioningLog appends the output of the up scripts of the steps to
+// LogsDir as they complete, and keeps the summary up to date. The output of
+// the down scripts is appended by downCommand.
+type provisioningLog struct {
+	mutex   sync.Mutex
+	dir     string
+	mask    *strings.Replacer
+	summary Summary
+}
+
+func newProvisioningLog(stack string, settings *config.Settings, steps []Step) *provisioningLog {
+	log := &provisioningLog{
+		dir:     LogsDir(stack),
+		mask:    settings.MaskSecrets(),
+		summary: Summary{Stack: stack},
+	}
+	for _, step := range steps {
+		log.summary.Steps = append(log.summary.Steps, StepResult{Step: step.Name, Status: StatusNotRun})
+	}
+	return log
+}
+
+// previousStatuses returns the status of the steps on the last deploy
+func (log *provisioningLog) previousStatuses() map[string]string {
+	statuses := map[string]string{}
+	b, err := ioutil.ReadFile(filepath.Join(log.dir, "summary.json"))
+	if err != nil {
+		return statuses
+	}
+	previous := Summary{}
+	if json.Unmarshal(b, &previous) == nil {
+		for _, step := range previous.Steps {
+			statuses[step.Step] = step.Status
+		}
+	}
+	return statuses
+}
+
+// start writes the summary before the steps run
+func (log *provisioningLog) start() error {
+	if err := os.MkdirAll(log.dir, 0700); err != nil {
+		return err
+	}
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	return log.writeSummary()
+}
+
+// record appends the output of a step to its log, and its result in the
+// summary
+func (log *provisioningLog) record(name string, stdout string) (StepResult, error) {
+	result, output := parseResult(name, log.mask.Replace(stdout))
+	result.Log = filepath.Join(log.dir, name+".log")
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) > lastLines {
+		lines = lines[len(lines)-lastLines:]
+	}
+	result.LastLines = lines
+	started := result.Started
+	if started == "" {
+		started = time.Now().UTC().Format(time.RFC3339)
+	}
+	// after the output of the down script when the step was replaced, see
+	// downCommand
+	file, err := os.OpenFile(result.Log, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return result, err
+	}
+	_, err = fmt.Fprintf(file, "--- up %s\n%s", started, output)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return result, err
+	}
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	for i, step := range log.summary.Steps {
+		if step.Step == name {
+			log.summary.Steps[i] = result
+		}
+	}
+	return result, log.writeSummary()
+}
+
+// recordOk records the success of a step without output, eg. a files step
+func (log *provisioningLog) recordOk(name string) error {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	for i, step := range log.summary.Steps {
+		if step.Step == name {
+			log.summary.Steps[i] = StepResult{Step: name, Status: StatusOk}
+		}
+	}
+	return log.writeSummary()
+}
+
+func (log *provisioningLog) writeSummary() error {
+	log.summary.Updated = time.Now().UTC().Format(time.RFC3339)
+	b, err := json.MarshalIndent(log.summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(log.dir, "summary.json"), append(b, '\n'), 0600)
+}
+
+// stepError reports a failed step with the end of its output
+func stepError(result StepResult) error {
+	return fmt.Errorf("provisioning step %s failed with status %d, see %s:\n%s",
+		result.Step, result.ExitStatus, result.Log, strings.Join(result.LastLines, "\n"))
+}
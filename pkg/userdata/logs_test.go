@@ -0,0 +1,113 @@
+package userdata
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+)
+
+func TestParseResult(t *testing.T) {
+	testCases := []struct {
+		name     string
+		stdout   string
+		expected StepResult
+		output   string
+	}{
+		{
+			name:     "success",
+			stdout:   "installing\ndone\nmob-step: status=0 attempts=1 seconds=42 started=1700000000\n",
+			expected: StepResult{Step: "setup.sh", Status: StatusOk, Attempts: 1, Seconds: 42, Started: "2023-11-14T22:13:20Z"},
+			output:   "installing\ndone\n",
+		},
+		{
+			name:     "failure",
+			stdout:   "step setup.sh failed with status 124\nmob-step: status=124 attempts=3 seconds=900 started=1700000000",
+			expected: StepResult{Step: "setup.sh", Status: StatusFailed, ExitStatus: 124, Attempts: 3, Seconds: 900, Started: "2023-11-14T22:13:20Z"},
+			output:   "step setup.sh failed with status 124\n",
+		},
+		{
+			name:     "already completed",
+			stdout:   "step setup.sh already completed on this machine, skipped\nmob-step: status=0 attempts=0 seconds=0 started=1700000000\n",
+			expected: StepResult{Step: "setup.sh", Status: StatusSkipped, Started: "2023-11-14T22:13:20Z"},
+			output:   "step setup.sh already completed on this machine, skipped\n",
+		},
+		{
+			name:     "no status line",
+			stdout:   "bash: base64: command not found\n",
+			expected: StepResult{Step: "setup.sh", Status: StatusFailed, ExitStatus: -1},
+			output:   "bash: base64: command not found\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, output := parseResult("setup.sh", tc.stdout)
+			if result.Step != tc.expected.Step || result.Status != tc.expected.Status || result.ExitStatus != tc.expected.ExitStatus ||
+				result.Attempts != tc.expected.Attempts || result.Seconds != tc.expected.Seconds || result.Started != tc.expected.Started {
+				t.Errorf("expected %+v, got %+v", tc.expected, result)
+			}
+			if output != tc.output {
+				t.Errorf("expected output %q, got %q", tc.output, output)
+			}
+		})
+	}
+}
+
+func TestParseOutputs(t *testing.T) {
+	stdout := "installing\nmob-output: version=1.2.3\nmob-output: password=a=b\nmob-output: version=1.2.4\n" +
+		"mob-output: malformed\nmob-step: status=0 attempts=1 seconds=42 started=1700000000\n"
+	outputs, output := parseOutputs(stdout)
+	expected := map[string]string{"version": "1.2.4", "password": "a=b"}
+	if !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("expected %v, got %v", expected, outputs)
+	}
+	if output != "installing\nmob-step: status=0 attempts=1 seconds=42 started=1700000000\n" {
+		t.Errorf("unexpected output %q", output)
+	}
+}
+
+func TestProvisioningLog(t *testing.T) {
+	settings := &config.Settings{Gitlab: config.ConcurrentVersionsSystemInfo{Token: "glpat-secret"}}
+	steps := []Step{{SeqEntry: SeqEntry{Name: "setup.sh"}}, {SeqEntry: SeqEntry{Name: "git.sh"}}}
+	log := newProvisioningLog("dev", settings, steps)
+	log.dir = filepath.Join(t.TempDir(), "dev")
+	if err := log.start(); err != nil {
+		t.Fatal(err)
+	}
+	// the output of the down script of the replaced step
+	down := "--- down 2023-11-14T22:13:00Z\nremoved\n"
+	if err := os.WriteFile(filepath.Join(log.dir, "setup.sh.log"), []byte(down), 0600); err != nil {
+		t.Fatal(err)
+	}
+	result, err := log.record("setup.sh", "cloning with glpat-secret\nfatal: denied\nmob-step: status=128 attempts=1 seconds=3 started=1700000000\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stepError(result); !strings.Contains(err.Error(), "provisioning step setup.sh failed with status 128") ||
+		!strings.HasSuffix(err.Error(), "cloning with ********\nfatal: denied") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	b, err := os.ReadFile(result.Log)
+	if err != nil || string(b) != down+"--- up 2023-11-14T22:13:20Z\ncloning with ********\nfatal: denied\n" {
+		t.Errorf("unexpected log %q, %v", b, err)
+	}
+	b, err = os.ReadFile(filepath.Join(log.dir, "summary.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := Summary{}
+	if err := json.Unmarshal(b, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Steps) != 2 || summary.Steps[0].Status != StatusFailed || summary.Steps[1].Status != StatusNotRun {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if statuses := log.previousStatuses(); statuses["setup.sh"] != StatusFailed || statuses["git.sh"] != StatusNotRun {
+		t.Errorf("unexpected statuses %v", statuses)
+	}
+}
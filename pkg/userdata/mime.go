@@ -0,0 +1,109 @@
+package userdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// MaxUserDataSize is the EC2 limit on user-data, before base64 encoding
+const MaxUserDataSize = 16 * 1024
+
+// mimeBoundary is fixed so that the same parts always give the same
+// document, and pulumi sees no change
+const mimeBoundary = "MOBSERVER-USERDATA-BOUNDARY"
+
+// contentTypes maps the content_type of a sequence entry to the MIME type
+// cloud-init reads
+var contentTypes = map[string]string{
+	"shellscript":  "text/x-shellscript",
+	"cloud-config": "text/cloud-config",
+	"include-url":  "text/x-include-url",
+	"boothook":     "text/cloud-boothook",
+}
+
+// Part is a rendered user-data script
+type Part struct {
+	Name        string
+	ContentType string // as in sequence.yml, eg. shellscript
+	Content     string
+}
+
+// UserData is what cloud-init runs when the machine first boots
+type UserData struct {
+	Parts []Part
+}
+
+// MIME returns the multipart MIME document of the parts, empty when there
+// are none
+func (userData *UserData) MIME() ([]byte, error) {
+	if len(userData.Parts) == 0 {
+		return nil, nil
+	}
+	body := bytes.Buffer{}
+	writer := multipart.NewWriter(&body)
+	if err := writer.SetBoundary(mimeBoundary); err != nil {
+		return nil, err
+	}
+	for _, part := range userData.Parts {
+		if strings.Contains(part.Content, mimeBoundary) {
+			return nil, fmt.Errorf("%s contains the user-data MIME boundary %s", part.Name, mimeBoundary)
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", fmt.Sprintf(`%s; charset="utf-8"`, contentTypes[part.ContentType]))
+		header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, part.Name))
+		header.Set("MIME-Version", "1.0")
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(part.Content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	document := fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"\nMIME-Version: 1.0\n\n", mimeBoundary)
+	return append([]byte(document), body.Bytes()...), nil
+}
+
+// Encode returns the base64 encoded document for EC2, gzip compressed when
+// over MaxUserDataSize, failing when it still doesn't fit
+func (userData *UserData) Encode() (string, error) {
+	document, err := userData.MIME()
+	if err != nil || len(document) == 0 {
+		return "", err
+	}
+	if len(document) > MaxUserDataSize {
+		// cloud-init recognizes gzip data
+		compressed := bytes.Buffer{}
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(document)
+		if err := writer.Close(); err != nil {
+			return "", err
+		}
+		if compressed.Len() > MaxUserDataSize {
+			return "", userData.sizeError(len(document), compressed.Len())
+		}
+		document = compressed.Bytes()
+	}
+	return base64.StdEncoding.EncodeToString(document), nil
+}
+
+// sizeError explains which parts take the room
+func (userData *UserData) sizeError(size int, compressed int) error {
+	parts := append([]Part{}, userData.Parts...)
+	sort.SliceStable(parts, func(i, j int) bool { return len(parts[i].Content) > len(parts[j].Content) })
+	sizes := []string{}
+	for _, part := range parts {
+		sizes = append(sizes, fmt.Sprintf("%s (%d bytes)", part.Name, len(part.Content)))
+	}
+	return fmt.Errorf("user-data is %d bytes, %d gzip compressed, over the EC2 limit of %d bytes: "+
+		"move some of %s to the provisioning scripts", size, compressed, MaxUserDataSize, strings.Join(sizes, ", "))
+}
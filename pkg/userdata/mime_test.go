@@ -0,0 +1,87 @@
+package userdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"math/rand"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestUserDataMIME(t *testing.T) {
+	userData := &UserData{Parts: []Part{
+		{Name: "swap.sh", ContentType: "shellscript", Content: "#!/bin/bash\nfallocate -l 4G /swapfile\n"},
+		{Name: "packages.yml", ContentType: "cloud-config", Content: "#cloud-config\npackages: [jq]\n"},
+	}}
+	document, err := userData.MIME()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	message, err := mail.ReadMessage(bytes.NewReader(document))
+	if err != nil {
+		t.Fatalf("unreadable document: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("unexpected content type %q: %v", mediaType, err)
+	}
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for _, expected := range []struct{ contentType, content string }{
+		{"text/x-shellscript", userData.Parts[0].Content},
+		{"text/cloud-config", userData.Parts[1].Content},
+	} {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("missing part: %v", err)
+		}
+		content, _ := io.ReadAll(part)
+		if !strings.HasPrefix(part.Header.Get("Content-Type"), expected.contentType) || string(content) != expected.content {
+			t.Errorf("unexpected part %v:\n%s", part.Header, content)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("expected 2 parts only, got %v", err)
+	}
+}
+
+func TestUserDataEncode(t *testing.T) {
+	empty, err := (&UserData{}).Encode()
+	if err != nil || empty != "" {
+		t.Errorf("expected no user-data, got %q %v", empty, err)
+	}
+
+	// compressible, over the limit
+	large := &UserData{Parts: []Part{
+		{Name: "large.sh", ContentType: "shellscript", Content: strings.Repeat("echo hello world\n", 2000)},
+	}}
+	encoded, err := large.Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(encoded)
+	if len(decoded) > MaxUserDataSize {
+		t.Errorf("expected at most %d bytes, got %d", MaxUserDataSize, len(decoded))
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(decoded))
+	if err != nil {
+		t.Fatalf("expected gzip data: %v", err)
+	}
+	if document, _ := io.ReadAll(reader); !bytes.Contains(document, []byte("echo hello world")) {
+		t.Errorf("unexpected document after decompressing")
+	}
+
+	// incompressible, over the limit
+	random := make([]byte, 2*MaxUserDataSize)
+	rand.New(rand.NewSource(1)).Read(random)
+	tooLarge := &UserData{Parts: []Part{
+		{Name: "blob.sh", ContentType: "shellscript", Content: base64.StdEncoding.EncodeToString(random)},
+	}}
+	if _, err := tooLarge.Encode(); err == nil || !strings.Contains(err.Error(), "blob.sh") {
+		t.Errorf("expected an error naming the part, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package userdata
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+	"github.com/slim-ai/mob-code-server/scripts"
+)
+
+// Scripts returns the scripts tree: the built-in scripts, overlaid by the
+// files of settings.scripts_dir when set. A file of the overlay replaces
+// the built-in file at the same path, and new files are added.
+func Scripts(settings *config.Settings) (fs.FS, error) {
+	if settings.ScriptsDir == "" {
+		return scripts.FS, nil
+	}
+	info, err := os.Stat(settings.ScriptsDir)
+	if err != nil {
+		return nil, fmt.Errorf("settings.scripts_dir: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("settings.scripts_dir: %s is not a directory", settings.ScriptsDir)
+	}
+	return overlayFS{upper: os.DirFS(settings.ScriptsDir), lower: scripts.FS}, nil
+}
+
+// overlayFS reads the files of upper, then the ones of lower
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+func (overlay overlayFS) Open(name string) (fs.File, error) {
+	file, err := overlay.upper.Open(name)
+	if err == nil {
+		return file, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return overlay.lower.Open(name)
+}
+
+// ReadDir merges the entries of both, the upper ones first
+func (overlay overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, upperErr := fs.ReadDir(overlay.upper, name)
+	lower, lowerErr := fs.ReadDir(overlay.lower, name)
+	if upperErr != nil && lowerErr != nil {
+		return nil, lowerErr
+	}
+	entries := map[string]fs.DirEntry{}
+	for _, entry := range lower {
+		entries[entry.Name()] = entry
+	}
+	for _, entry := range upper {
+		entries[entry.Name()] = entry
+	}
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		merged = append(merged, entry)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	return merged, nil
+}
@@ -0,0 +1,80 @@
+package userdata
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+)
+
+func TestOverlayFS(t *testing.T) {
+	overlay := overlayFS{
+		upper: fstest.MapFS{
+			"ubuntu/provisioning/team.sh":  {Data: []byte("echo custom team\n")},
+			"ubuntu/provisioning/extra.sh": {Data: []byte("echo extra\n")},
+		},
+		lower: fstest.MapFS{
+			"ubuntu/provisioning/setup.sh": {Data: []byte("echo setup\n")},
+			"ubuntu/provisioning/team.sh":  {Data: []byte("echo team\n")},
+		},
+	}
+	for name, expected := range map[string]string{
+		"ubuntu/provisioning/team.sh":  "echo custom team\n",
+		"ubuntu/provisioning/extra.sh": "echo extra\n",
+		"ubuntu/provisioning/setup.sh": "echo setup\n",
+	} {
+		if b, err := fs.ReadFile(overlay, name); err != nil || string(b) != expected {
+			t.Errorf("expected %q for %s, got %q, %v", expected, name, b, err)
+		}
+	}
+	names := []string{}
+	if err := fs.WalkDir(overlay, "ubuntu", func(name string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			names = append(names, name)
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "ubuntu/provisioning/extra.sh,ubuntu/provisioning/setup.sh,ubuntu/provisioning/team.sh" {
+		t.Errorf("unexpected files %v", names)
+	}
+	if _, err := fs.ReadFile(overlay, "ubuntu/provisioning/missing.sh"); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestScripts(t *testing.T) {
+	fsys, err := Scripts(&config.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getProvisioningScripts(fsys, "ubuntu"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	dir := t.TempDir()
+	team := filepath.Join(dir, "ubuntu", "provisioning", "team.sh")
+	if err := os.MkdirAll(filepath.Dir(team), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(team, []byte("echo custom team\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if fsys, err = Scripts(&config.Settings{ScriptsDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := fs.ReadFile(fsys, "ubuntu/provisioning/team.sh"); err != nil || string(b) != "echo custom team\n" {
+		t.Errorf("expected the overlay team.sh, got %q, %v", b, err)
+	}
+	if _, err := fs.Stat(fsys, "ubuntu/provisioning/setup.sh"); err != nil {
+		t.Errorf("expected the built-in setup.sh, got %v", err)
+	}
+	if _, err := Scripts(&config.Settings{ScriptsDir: team}); err == nil || !strings.Contains(err.Error(), "is not a directory") {
+		t.Errorf("expected an error for a file, got %v", err)
+	}
+}
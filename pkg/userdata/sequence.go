@@ -0,0 +1,323 @@
+package userdata
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slim-ai/mob-code-server/pkg/components"
+	"github.com/slim-ai/mob-code-server/pkg/config"
+	"gopkg.in/yaml.v2"
+)
+
+type ProvisioningSequence struct {
+	Sequence []SeqEntry `yaml:"sequence"`
+}
+
+// SeqEntry is a step of sequence.yml
+type SeqEntry struct {
+	Name    string            `yaml:"name"` // defaults to the up script file name
+	Up      string            `yaml:"up"`
+	Down    string            `yaml:"down"`
+	When    string            `yaml:"when"`    // condition on the settings, eg. github.enabled
+	Retries int               `yaml:"retries"` // attempts after the first one fails
+	Backoff string            `yaml:"backoff"` // wait before the first retry, doubled on each one, eg. 10s
+	Timeout string            `yaml:"timeout"` // of each attempt, eg. 15m
+	RunAs   string            `yaml:"run_as"`  // defaults to the login user
+	Env     map[string]string `yaml:"env"`
+	// shell (default), ansible (provisioning only, up is the playbook) or
+	// cloud-config (user-data only, the same as its content_type)
+	Type string `yaml:"type"`
+	// user-data only: shellscript (default), cloud-config, include-url or boothook
+	ContentType string `yaml:"content_type"`
+	// provisioning only: true for the entry standing for the component steps
+	Components bool     `yaml:"components"`
+	Requires   []string `yaml:"requires"` // components the step needs, eg. go
+	// provisioning only: files uploaded instead of running scripts
+	Files []FileEntry `yaml:"files"`
+	// provisioning only: a step waits for the previous one unless it is in
+	// the same group, or for the steps and groups listed in after
+	Group    string   `yaml:"group"`
+	After    []string `yaml:"after"`
+	Parallel bool     `yaml:"parallel"` // components only: each one only waits for the ones it requires
+	// provisioning only: the values the script writes to $MOB_OUTPUT that
+	// become stack outputs
+	Outputs []OutputEntry `yaml:"outputs"`
+
+	BackoffDuration time.Duration `yaml:"-"`
+	TimeoutDuration time.Duration `yaml:"-"`
+	// the steps waited for, resolved from group and after
+	DependsOn []string `yaml:"-"`
+}
+
+// OutputEntry declares an output of a provisioning step, exported as
+// outputs.<name>
+type OutputEntry struct {
+	Name   string `yaml:"name"`
+	Secret bool   `yaml:"secret"` // encrypted in the stack state, eg. a password
+}
+
+// defaultBackoff is the wait before the first retry when backoff is not set
+const defaultBackoff = 10 * time.Second
+
+var (
+	// eg. install-go, used to name the pulumi resource
+	stepNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+	// a linux user, or a placeholder like ___USERNAME___
+	runAsPattern = regexp.MustCompile(`^([a-z_][a-z0-9_-]{0,31}|___[A-Z0-9][A-Z0-9_]*___)$`)
+	envPattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// eg. code_server_password
+	outputNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+// getScripts reads directory/sequence.yml of the scripts tree
+func getScripts(fsys fs.FS, directory string) ([]SeqEntry, error) {
+	orderFile := path.Join(directory, "sequence.yml")
+	b, err := fs.ReadFile(fsys, orderFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := ProvisioningSequence{}
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", orderFile, err)
+	}
+	names := map[string]bool{}
+	outputs := map[string]bool{}
+	markers := 0
+	files := make([]SeqEntry, len(cfg.Sequence))
+	for i, entry := range cfg.Sequence {
+		if err := entry.validate(fsys, directory); err != nil {
+			return nil, fmt.Errorf("%s: sequence[%d]: %w", orderFile, i, err)
+		}
+		if entry.Components {
+			if markers++; markers > 1 {
+				return nil, fmt.Errorf("%s: sequence[%d]: only one entry may set components", orderFile, i)
+			}
+		}
+		if names[entry.Name] {
+			return nil, fmt.Errorf("%s: sequence[%d]: name %q is used by another step", orderFile, i, entry.Name)
+		}
+		names[entry.Name] = true
+		for _, output := range entry.Outputs {
+			if outputs[output.Name] {
+				return nil, fmt.Errorf("%s: sequence[%d]: output %q is set by another step", orderFile, i, output.Name)
+			}
+			outputs[output.Name] = true
+		}
+		files[i] = entry
+	}
+	return files, nil
+}
+
+// validate checks the entry and resolves its files and durations
+func (entry *SeqEntry) validate(fsys fs.FS, directory string) error {
+	if err := entry.validateType(); err != nil {
+		return err
+	}
+	if entry.Components {
+		// the component steps bring their own scripts
+		if entry.Name != "" || entry.Up != "" || entry.Down != "" || len(entry.Requires) > 0 || len(entry.Files) > 0 || len(entry.Outputs) > 0 {
+			return fmt.Errorf("components can't be set with name, up, down, requires, files or outputs")
+		}
+		entry.Name = "components"
+	} else if entry.Parallel {
+		return fmt.Errorf("parallel is only for the components entry")
+	} else if len(entry.Files) > 0 {
+		if len(entry.Outputs) > 0 {
+			return fmt.Errorf("outputs are only for the steps running scripts")
+		}
+		if err := entry.validateFiles(fsys, directory); err != nil {
+			return err
+		}
+	} else if entry.Type == TypeAnsible {
+		if err := entry.validateAnsible(fsys, directory); err != nil {
+			return err
+		}
+	} else if err := entry.validateScripts(fsys, directory); err != nil {
+		return err
+	}
+	for _, name := range entry.Requires {
+		if _, version, err := components.Parse(name); err != nil {
+			return fmt.Errorf("requires: %w", err)
+		} else if version != "" {
+			return fmt.Errorf("requires: %q can't set a version, use settings.components", name)
+		}
+	}
+	if entry.ContentType == "" {
+		entry.ContentType = "shellscript"
+	}
+	if _, ok := contentTypes[entry.ContentType]; !ok {
+		types := []string{}
+		for name := range contentTypes {
+			types = append(types, name)
+		}
+		sort.Strings(types)
+		return fmt.Errorf("content_type must be one of %s, got %q", strings.Join(types, ", "), entry.ContentType)
+	}
+	if entry.Group != "" && !stepNamePattern.MatchString(entry.Group) {
+		return fmt.Errorf("group %q must be letters, digits, '.', '_' and '-'", entry.Group)
+	}
+	if _, err := parseCondition(entry.When); err != nil {
+		return fmt.Errorf("when: %w", err)
+	}
+	if entry.Retries < 0 {
+		return fmt.Errorf("retries must be positive, got %d", entry.Retries)
+	}
+	entry.BackoffDuration = defaultBackoff
+	if entry.Backoff != "" {
+		duration, err := time.ParseDuration(entry.Backoff)
+		if err != nil || duration < time.Second {
+			return fmt.Errorf("backoff must be a duration of at least 1s (eg. 30s), got %q", entry.Backoff)
+		}
+		entry.BackoffDuration = duration
+	}
+	if entry.Timeout != "" {
+		duration, err := time.ParseDuration(entry.Timeout)
+		if err != nil || duration < time.Second {
+			return fmt.Errorf("timeout must be a duration of at least 1s (eg. 15m), got %q", entry.Timeout)
+		}
+		entry.TimeoutDuration = duration
+	}
+	if entry.RunAs != "" && !runAsPattern.MatchString(entry.RunAs) {
+		return fmt.Errorf("run_as must be a linux user name or a placeholder like ___USERNAME___, got %q", entry.RunAs)
+	}
+	for name := range entry.Env {
+		if !envPattern.MatchString(name) {
+			return fmt.Errorf("env: %q is not a valid variable name", name)
+		}
+	}
+	for i, output := range entry.Outputs {
+		if !outputNamePattern.MatchString(output.Name) {
+			return fmt.Errorf("outputs[%d]: name %q must be lower case letters, digits and '_'", i, output.Name)
+		}
+	}
+	return nil
+}
+
+// validateType checks the type of the entry, a cloud-config one taking the
+// cloud-config content_type and the other way round
+func (entry *SeqEntry) validateType() error {
+	switch {
+	case entry.Type == "" && entry.ContentType == "cloud-config":
+		entry.Type = TypeCloudConfig
+	case entry.Type == "":
+		entry.Type = TypeShell
+	case entry.Type == TypeCloudConfig:
+		if entry.ContentType != "" && entry.ContentType != "cloud-config" {
+			return fmt.Errorf("content_type must be cloud-config for type cloud-config, got %q", entry.ContentType)
+		}
+		entry.ContentType = "cloud-config"
+	case entry.Type != TypeShell && entry.Type != TypeAnsible:
+		return fmt.Errorf("type must be one of %s, %s or %s, got %q", TypeShell, TypeAnsible, TypeCloudConfig, entry.Type)
+	}
+	if entry.Type != TypeShell && (entry.Components || len(entry.Files) > 0) {
+		return fmt.Errorf("type %s can't be set with components or files", entry.Type)
+	}
+	return nil
+}
+
+// validateScripts checks and resolves the up and down scripts of a step
+func (entry *SeqEntry) validateScripts(fsys fs.FS, directory string) error {
+	if entry.Up == "" {
+		return fmt.Errorf("up must be set")
+	}
+	if entry.Name == "" {
+		entry.Name = path.Base(entry.Up)
+	}
+	if !stepNamePattern.MatchString(entry.Name) {
+		return fmt.Errorf("name %q must be letters, digits, '.', '_' and '-'", entry.Name)
+	}
+	var err error
+	if entry.Up, err = findScript(fsys, directory, entry.Up); err != nil {
+		return fmt.Errorf("up: %w", err)
+	}
+	if entry.Down != "" {
+		if entry.Down, err = findScript(fsys, directory, entry.Down); err != nil {
+			return fmt.Errorf("down: %w", err)
+		}
+	}
+	return nil
+}
+
+// findScript returns the path of a script of directory in the scripts
+// tree, or of the same directory under common/ when the distribution
+// doesn't have it, eg. common/provisioning/git.sh for
+// ubuntu/provisioning/git.sh
+func findScript(fsys fs.FS, directory string, name string) (string, error) {
+	script := path.Join(directory, name)
+	_, err := fs.Stat(fsys, script)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return script, err
+	}
+	common := path.Join("common", path.Base(directory), name)
+	if _, commonErr := fs.Stat(fsys, common); commonErr == nil {
+		return common, nil
+	}
+	return script, err
+}
+
+// condition is the `when` of a step: a setting path, true when set
+// (eg. github.enabled or team), negated with a leading !, or compared with
+// == and != (eg. instance.resource_type == spot)
+type condition struct {
+	path     string
+	negate   bool
+	operator string
+	value    string
+}
+
+func parseCondition(when string) (*condition, error) {
+	when = strings.TrimSpace(when)
+	if when == "" {
+		return nil, nil
+	}
+	cond := &condition{}
+	for _, operator := range []string{"==", "!="} {
+		if i := strings.Index(when, operator); i >= 0 {
+			cond.operator = operator
+			cond.value = strings.TrimSpace(when[i+len(operator):])
+			when = strings.TrimSpace(when[:i])
+			break
+		}
+	}
+	if cond.operator == "" && strings.HasPrefix(when, "!") {
+		cond.negate = true
+		when = strings.TrimSpace(when[1:])
+	}
+	cond.path = when
+	if _, ok := (&config.Settings{}).Value(cond.path); !ok {
+		return nil, fmt.Errorf("%q is not a setting (eg. github.enabled)", cond.path)
+	}
+	return cond, nil
+}
+
+// evaluate returns whether a step runs for the settings
+func (cond *condition) evaluate(settings *config.Settings) bool {
+	if cond == nil {
+		return true
+	}
+	value, _ := settings.Value(cond.path)
+	switch cond.operator {
+	case "==":
+		return fmt.Sprint(value.Interface()) == cond.value
+	case "!=":
+		return fmt.Sprint(value.Interface()) != cond.value
+	}
+	set := !value.IsZero()
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Map {
+		set = value.Len() > 0
+	}
+	return set != cond.negate
+}
+
+// runs returns whether the step runs for the settings
+func (entry *SeqEntry) runs(settings *config.Settings) bool {
+	cond, _ := parseCondition(entry.When) // validated by getScripts
+	return cond.evaluate(settings)
+}
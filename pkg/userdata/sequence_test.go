@@ -0,0 +1,350 @@
+package userdata
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+	"github.com/slim-ai/mob-code-server/scripts"
+)
+
+func sequenceFS(sequence string) fstest.MapFS {
+	return fstest.MapFS{
+		"sequence.yml": {Data: []byte(sequence)},
+		"setup.sh":     {Data: []byte("echo setup\n")},
+		"shutdown.sh":  {Data: []byte("echo shutdown\n")},
+	}
+}
+
+func TestGetScripts(t *testing.T) {
+	testCases := []struct {
+		name        string
+		sequence    string
+		expectedErr string
+	}{
+		{
+			name: "valid sequence",
+			sequence: `sequence:
+  - up: setup.sh
+    down: shutdown.sh
+    when: "!team"
+    retries: 2
+    backoff: 30s
+    timeout: 15m
+    run_as: ___USERNAME___
+    env:
+      GOPRIVATE: ___GOPRIVATE___
+  - name: again
+    up: setup.sh
+    when: instance.resource_type == spot
+    requires: [go]
+  - components: true
+`,
+		},
+		{
+			name:        "missing up script",
+			sequence:    "sequence:\n  - down: shutdown.sh\n",
+			expectedErr: "sequence[0]: up must be set",
+		},
+		{
+			name:        "unknown script",
+			sequence:    "sequence:\n  - up: install.sh\n",
+			expectedErr: "sequence[0]: up:",
+		},
+		{
+			name:        "unknown key",
+			sequence:    "sequence:\n  - up: setup.sh\n    retry: 2\n",
+			expectedErr: "field retry not found",
+		},
+		{
+			name:        "unknown setting",
+			sequence:    "sequence:\n  - up: setup.sh\n    when: github.enable\n",
+			expectedErr: `sequence[0]: when: "github.enable" is not a setting`,
+		},
+		{
+			name:        "malformed timeout",
+			sequence:    "sequence:\n  - up: setup.sh\n    timeout: 15\n",
+			expectedErr: "sequence[0]: timeout must be a duration",
+		},
+		{
+			name:        "malformed user",
+			sequence:    "sequence:\n  - up: setup.sh\n    run_as: \"root; rm -rf /\"\n",
+			expectedErr: "sequence[0]: run_as must be a linux user name",
+		},
+		{
+			name:        "components with a script",
+			sequence:    "sequence:\n  - components: true\n    up: setup.sh\n",
+			expectedErr: "sequence[0]: components can't be set with name, up, down, requires, files or outputs",
+		},
+		{
+			name:        "two components entries",
+			sequence:    "sequence:\n  - components: true\n  - components: true\n",
+			expectedErr: "sequence[1]: only one entry may set components",
+		},
+		{
+			name:        "unknown requirement",
+			sequence:    "sequence:\n  - up: setup.sh\n    requires: [rust]\n",
+			expectedErr: `sequence[0]: requires: unknown component "rust"`,
+		},
+		{
+			name:        "files without a name",
+			sequence:    "sequence:\n  - files:\n      - content: x\n        destination: /etc/x\n",
+			expectedErr: "sequence[0]: name must be set for files",
+		},
+		{
+			name:        "files with a script",
+			sequence:    "sequence:\n  - name: x\n    up: setup.sh\n    files:\n      - content: x\n        destination: /etc/x\n",
+			expectedErr: "sequence[0]: files can't be set with up",
+		},
+		{
+			name:        "file with source and content",
+			sequence:    "sequence:\n  - name: x\n    files:\n      - source: setup.sh\n        content: x\n        destination: /etc/x\n",
+			expectedErr: "sequence[0]: files[0]: either source or content must be set",
+		},
+		{
+			name:        "malformed mode",
+			sequence:    "sequence:\n  - name: x\n    files:\n      - content: x\n        destination: /etc/x\n        mode: rw\n",
+			expectedErr: "sequence[0]: files[0]: mode must be octal",
+		},
+		{
+			name:        "duplicate names",
+			sequence:    "sequence:\n  - up: setup.sh\n  - up: setup.sh\n",
+			expectedErr: `sequence[1]: name "setup.sh" is used by another step`,
+		},
+		{
+			name:        "unknown type",
+			sequence:    "sequence:\n  - up: setup.sh\n    type: puppet\n",
+			expectedErr: `sequence[0]: type must be one of shell, ansible or cloud-config, got "puppet"`,
+		},
+		{
+			name:        "ansible with a script",
+			sequence:    "sequence:\n  - up: setup.sh\n    type: ansible\n",
+			expectedErr: "sequence[0]: up: setup.sh is not a playbook",
+		},
+		{
+			name:        "ansible with a down script",
+			sequence:    "sequence:\n  - up: setup.sh\n    down: shutdown.sh\n    type: ansible\n",
+			expectedErr: "sequence[0]: down can't be set for ansible steps",
+		},
+		{
+			name:        "cloud-config with another content type",
+			sequence:    "sequence:\n  - up: setup.sh\n    type: cloud-config\n    content_type: boothook\n",
+			expectedErr: `sequence[0]: content_type must be cloud-config for type cloud-config, got "boothook"`,
+		},
+		{
+			name:        "components with a type",
+			sequence:    "sequence:\n  - components: true\n    type: ansible\n",
+			expectedErr: "sequence[0]: type ansible can't be set with components or files",
+		},
+		{
+			name:        "malformed output name",
+			sequence:    "sequence:\n  - up: setup.sh\n    outputs:\n      - name: Password\n",
+			expectedErr: `sequence[0]: outputs[0]: name "Password" must be lower case letters`,
+		},
+		{
+			name:        "output of a files step",
+			sequence:    "sequence:\n  - name: x\n    files:\n      - content: x\n        destination: /etc/x\n    outputs:\n      - name: x\n",
+			expectedErr: "sequence[0]: outputs are only for the steps running scripts",
+		},
+		{
+			name:        "duplicate outputs",
+			sequence:    "sequence:\n  - up: setup.sh\n    outputs:\n      - name: version\n  - name: again\n    up: setup.sh\n    outputs:\n      - name: version\n",
+			expectedErr: `sequence[1]: output "version" is set by another step`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, err := getScripts(sequenceFS(tc.sequence), ".")
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected an error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if entries[0].Name != "setup.sh" || entries[0].TimeoutDuration != 15*time.Minute ||
+				entries[0].BackoffDuration != 30*time.Second || entries[1].BackoffDuration != defaultBackoff {
+				t.Errorf("unexpected entries %+v", entries)
+			}
+		})
+	}
+}
+
+func TestCommonScripts(t *testing.T) {
+	fsys := fstest.MapFS{
+		"ubuntu/provisioning/sequence.yml": {Data: []byte("sequence:\n  - up: setup.sh\n  - up: git.sh\n")},
+		"ubuntu/provisioning/setup.sh":     {Data: []byte("echo setup\n")},
+		"common/provisioning/git.sh":       {Data: []byte("echo git\n")},
+		"common/provisioning/setup.sh":     {Data: []byte("echo common setup\n")},
+	}
+	entries, err := getScripts(fsys, "ubuntu/provisioning")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries[0].Up != "ubuntu/provisioning/setup.sh" || entries[1].Up != "common/provisioning/git.sh" || entries[1].Name != "git.sh" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+	for _, distDir := range []string{"ubuntu", "amazon-linux"} {
+		if _, err := getProvisioningScripts(scripts.FS, distDir); err != nil {
+			t.Errorf("%s: unexpected error: %v", distDir, err)
+		}
+		if _, err := getUserDataScripts(scripts.FS, distDir); err != nil {
+			t.Errorf("%s: unexpected error: %v", distDir, err)
+		}
+	}
+}
+
+func TestConditions(t *testing.T) {
+	settings := &config.Settings{
+		Github:      config.ConcurrentVersionsSystemInfo{Enabled: true},
+		MachineInfo: config.MachineInfo{ResourceType: "spot"},
+	}
+	for when, expected := range map[string]bool{
+		"":                               true,
+		"github.enabled":                 true,
+		"gitlab.enabled":                 false,
+		"!gitlab.enabled":                true,
+		"team":                           false,
+		"!team":                          true,
+		"instance.resource_type == spot": true,
+		"instance.resource_type != spot": false,
+	} {
+		entry := SeqEntry{When: when}
+		if actual := entry.runs(settings); actual != expected {
+			t.Errorf("expected %q to be %v", when, expected)
+		}
+	}
+}
+
+func TestStepCommand(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	// the marks of the completed steps
+	t.Setenv("HOME", t.TempDir())
+	counter := filepath.Join(t.TempDir(), "attempts")
+	step := Step{
+		SeqEntry: SeqEntry{
+			Name:            "flaky",
+			Retries:         1,
+			BackoffDuration: time.Second,
+			TimeoutDuration: time.Minute,
+			Env:             map[string]string{"GREETING": "it's me"},
+		},
+		// fails on the first attempt only
+		Create: `echo x >> "` + counter + `"
+[ "$(wc -l < "` + counter + `")" -gt 1 ] && echo "$GREETING" && echo "greeting=$GREETING" >> "$MOB_OUTPUT"`,
+	}
+	output, err := exec.Command("bash", "-c", step.Command()).Output()
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, output)
+	}
+	outputs, log := parseOutputs(string(output))
+	result, log := parseResult(step.Name, log)
+	if result.Status != StatusOk || result.Attempts != 2 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if !strings.Contains(log, "retrying in 1s (1/1)") || !strings.HasSuffix(log, "it's me\n") {
+		t.Errorf("unexpected output:\n%s", output)
+	}
+	if outputs["greeting"] != "it's me" {
+		t.Errorf("unexpected outputs %v", outputs)
+	}
+
+	// once completed, the step is skipped until its mark is removed
+	output, err = exec.Command("bash", "-c", step.Command()).Output()
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, output)
+	}
+	if result, _ := parseResult(step.Name, string(output)); result.Status != StatusSkipped {
+		t.Errorf("expected the completed step to be skipped, got %+v", result)
+	}
+	// with the outputs of the run that completed it
+	if outputs, _ := parseOutputs(string(output)); outputs["greeting"] != "it's me" {
+		t.Errorf("expected the outputs of the completed step, got %v", outputs)
+	}
+	if err := exec.Command("bash", "-c", step.UnmarkCommand()).Run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	os.Remove(counter)
+	output, _ = exec.Command("bash", "-c", step.Command()).Output()
+	if result, _ := parseResult(step.Name, string(output)); result.Status != StatusOk || result.Attempts != 2 {
+		t.Errorf("expected the unmarked step to run, got %+v", result)
+	}
+	// a step marked as completed doesn't run
+	os.Remove(counter)
+	if err := exec.Command("bash", "-c", step.UnmarkCommand()+step.MarkCommand()).Run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	output, _ = exec.Command("bash", "-c", step.Command()).Output()
+	if _, err := os.Stat(counter); err == nil || !strings.Contains(string(output), "already completed") {
+		t.Errorf("expected the marked step to be skipped:\n%s", output)
+	}
+
+	// the failure is reported in the status line
+	step.Retries = 0
+	os.Remove(counter)
+	output, err = exec.Command("bash", "-c", step.Command()).Output()
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, output)
+	}
+	if result, _ := parseResult(step.Name, string(output)); result.Status != StatusFailed || result.ExitStatus != 1 {
+		t.Errorf("expected the step to fail without retries, got %+v", result)
+	}
+	// the machine keeps the time of the failure
+	output, err = exec.Command("bash", "-c", FailuresCommand()).Output()
+	if failures := parseFailures(string(output)); err != nil || failures["flaky"] == "" || len(failures) != 1 {
+		t.Errorf("expected the failure of the step, got %v %v:\n%s", failures, err, output)
+	}
+	step.Delete = step.Create
+	os.Remove(counter)
+	if err := exec.Command("bash", "-c", step.MarkCommand()+step.DeleteCommand()).Run(); err == nil {
+		t.Errorf("expected the down script to fail")
+	}
+	// a failed down run keeps the mark of the step, a successful one removes it
+	marker := filepath.Join(os.Getenv("HOME"), ".mob-server", "steps", step.Name)
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("expected the mark of the step to be kept: %v", err)
+	}
+	for _, down := range []string{"true", ""} {
+		step.Delete = down
+		if err := exec.Command("bash", "-c", step.MarkCommand()+step.DeleteCommand()).Run(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := os.Stat(marker); !os.IsNotExist(err) {
+			t.Errorf("expected the down run %q to remove the mark of the step, got %v", down, err)
+		}
+	}
+}
+
+func TestStepHash(t *testing.T) {
+	step := Step{
+		SeqEntry: SeqEntry{Name: "setup.sh", BackoffDuration: defaultBackoff},
+		Create:   "echo setup\n",
+	}
+	hash := step.Hash()
+	if step.Hash() != hash {
+		t.Fatalf("expected the hash to be stable")
+	}
+	for name, change := range map[string]func(step *Step){
+		"script":  func(step *Step) { step.Create = "echo setup again\n" },
+		"down":    func(step *Step) { step.Delete = "echo shutdown\n" },
+		"env":     func(step *Step) { step.Env = map[string]string{"GOPRIVATE": "gitlab.com"} },
+		"user":    func(step *Step) { step.RunAs = "coder" },
+		"retries": func(step *Step) { step.Retries = 2 },
+	} {
+		changed := step
+		change(&changed)
+		if changed.Hash() == hash {
+			t.Errorf("expected a change of %s to change the hash", name)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package userdata
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+	"github.com/slim-ai/mob-code-server/pkg/templating"
+)
+
+// TeamScript returns the calls made by team.sh: one account per team
+// member (the primary user is created by setup.sh) and the Caddy routes
+// of everyone, primary user included.
+func TeamScript(settings *config.Settings) string {
+	lines := []string{}
+	sites := []string{}
+	for i, member := range settings.Members() {
+		sites = append(sites, templating.Quote(fmt.Sprintf("%s:%d", member.DomainName, member.Port)))
+		if i == 0 {
+			continue
+		}
+		args := []string{
+			templating.Quote(member.Name),
+			templating.Quote(fmt.Sprint(member.Port)),
+			templating.Quote(member.Git.Name),
+			templating.Quote(member.Git.Email),
+		}
+		for _, key := range member.SshKeys {
+			args = append(args, templating.Quote(key))
+		}
+		lines = append(lines, "add_team_member "+strings.Join(args, " "))
+	}
+	lines = append(lines, "write_caddyfile "+templating.Quote(settings.Email)+" "+strings.Join(sites, " "))
+	return strings.Join(lines, "\n")
+}
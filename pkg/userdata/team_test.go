@@ -0,0 +1,32 @@
+package userdata
+
+import (
+	"testing"
+
+	"github.com/slim-ai/mob-code-server/pkg/config"
+)
+
+func TestTeamScript(t *testing.T) {
+	settings := &config.Settings{
+		Email:      "me@email.com",
+		DomainName: "cod.dev.example.com",
+		MachineInfo: config.MachineInfo{
+			UserName: "coder",
+		},
+		Team: []config.TeamMember{
+			{
+				Name:    "alice",
+				Email:   "alice@email.com",
+				SshKeys: []string{"ssh-ed25519 AAAA alice@laptop"},
+				Git:     config.GitIdentity{Name: "Alice O'Hara"},
+			},
+			{Name: "bob"},
+		},
+	}
+	expected := `add_team_member 'alice' '8081' 'Alice O'\''Hara' 'alice@email.com' 'ssh-ed25519 AAAA alice@laptop'
+add_team_member 'bob' '8082' 'bob' ''
+write_caddyfile 'me@email.com' 'cod.dev.example.com:8080' 'alice.cod.dev.example.com:8081' 'bob.cod.dev.example.com:8082'`
+	if script := TeamScript(settings); script != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, script)
+	}
+}
@@ -1,170 +1,605 @@
 package userdata
 
 import (
-	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"io/fs"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pulumi/pulumi-command/sdk/go/command/remote"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/slim-ai/mob-code-server/pkg/components"
 	"github.com/slim-ai/mob-code-server/pkg/config"
-	"gopkg.in/yaml.v2"
+	"github.com/slim-ai/mob-code-server/pkg/diagnostics"
+	"github.com/slim-ai/mob-code-server/pkg/distro"
+	"github.com/slim-ai/mob-code-server/pkg/ready"
+	"github.com/slim-ai/mob-code-server/pkg/scriptcheck"
+	"github.com/slim-ai/mob-code-server/pkg/templating"
 )
 
-func RunProvisioningScripts(ctx *pulumi.Context, settings *config.Settings,
-	dependsOns []pulumi.Resource, templateFileHandler func(script string) string) error {
-	if templateFileHandler == nil {
-		return errors.New("templateFileHandler cannot be nil")
-	}
-	if scripts, err := getProvisioningScripts(settings.MachineInfo.OsDist); err != nil {
-		return err
-	} else {
-		for _, entry := range scripts {
-			createName := filepath.Base(entry.Up)
-			createScriptText, err := ioutil.ReadFile(entry.Up)
-			if err != nil {
-				return err
-			}
-			// XXX: This should be moved out to the settings
-			defaultUser := "ubuntu"
-			if settings.MachineInfo.OsDist == "arch" {
-				defaultUser = "arch"
+// Variables returns the values of the ___NAME___ placeholders known to the
+// scripts: the ones derived from the settings, then settings.variables
+func Variables(settings *config.Settings) map[string]string {
+	variables := map[string]string{}
+	for name, value := range settings.ExtraVariables {
+		variables[templating.VariableName(name)] = value
+	}
+	builtins := map[string]string{
+		"EMAIL__ADDRESS": settings.Email, // for FQDN certificate create/renewal
+		"USERNAME":       settings.MachineInfo.UserName,
+		"HOSTNAME":       settings.MachineInfo.Hostname,
+		"DOMAIN_NAME":    settings.DomainName,
+		"TEAM_MEMBERS":   TeamScript(settings),
+	}
+	if settings.Gitlab.Enabled {
+		// For preloading repositories from gitlab
+		builtins["GITLAB_TOKEN"] = settings.Gitlab.Token
+		builtins["GITLAB_REPOS"] = strings.Join(settings.Gitlab.Repositories, ",")
+	}
+	if settings.Github.Enabled {
+		// For preloading repositories from github
+		builtins["GITHUB_TOKEN"] = settings.Github.Token
+		builtins["GITHUB_REPOS"] = strings.Join(settings.Github.Repositories, ",")
+	}
+	for name, value := range builtins {
+		variables[name] = value
+	}
+	return variables
+}
+
+// Step is a provisioning script rendered for the settings, run on the
+// machine once it is up
+type Step struct {
+	SeqEntry
+	Create    string
+	Delete    string // run when tearing down, may be empty
+	Component string // the installed component, for component steps
+	Files     []File // the uploaded files, for files steps
+}
+
+// CreateName returns the up script file name
+func (step Step) CreateName() string {
+	if step.Component != "" || step.Type == TypeAnsible {
+		return step.Name + ".sh"
+	}
+	return path.Base(step.Up)
+}
+
+// DeleteName returns the down script file name
+func (step Step) DeleteName() string {
+	if step.Down == "" {
+		return ""
+	}
+	if step.Component != "" {
+		return step.Name + "-uninstall.sh"
+	}
+	return path.Base(step.Down)
+}
+
+// PlanProvisioning renders the provisioning scripts whose conditions hold
+// without running anything, reporting the problems of every script at once.
+// The component steps take the place of the components entry, or follow the
+// other steps when there is none. The steps are ordered so that each one
+// follows the steps of its DependsOn.
+func PlanProvisioning(settings *config.Settings) ([]Step, error) {
+	fsys, err := Scripts(settings)
+	if err != nil {
+		return nil, err
+	}
+	d, err := settings.Distribution()
+	if err != nil {
+		return nil, err
+	}
+	scripts, err := getProvisioningScripts(fsys, d.ScriptsDir())
+	if err != nil {
+		return nil, err
+	}
+	variables := Variables(settings)
+	engine := templating.New(settings, variables)
+	entries := []SeqEntry{}
+	required := []string{}
+	marker := false
+	all := map[string]SeqEntry{}
+	running := map[string]bool{}
+	for _, entry := range scripts {
+		all[entry.Name] = entry
+		running[entry.Name] = entry.runs(settings)
+	}
+	for _, entry := range scripts {
+		if !running[entry.Name] {
+			continue
+		}
+		marker = marker || entry.Components
+		entry.DependsOn = runningDependencies(entry, all, running)
+		entries = append(entries, entry)
+		required = append(required, entry.Requires...)
+	}
+	requested := settings.Components
+	if requested == nil {
+		requested = components.Defaults
+	}
+	selections, err := components.Resolve(requested, required, variables)
+	if err != nil {
+		return nil, fmt.Errorf("settings.components: %w", err)
+	}
+	if !marker {
+		last := SeqEntry{Name: "components", Components: true, BackoffDuration: defaultBackoff, DependsOn: []string{}}
+		for _, entry := range entries {
+			last.DependsOn = append(last.DependsOn, entry.Name)
+		}
+		entries = append(entries, last)
+	}
+
+	var problems templating.Problems
+	steps := []Step{}
+	// the steps standing for each entry, the components one has several
+	stepNames := map[string][]string{}
+	for _, entry := range sortEntries(entries) {
+		dependencies := []string{}
+		for _, name := range entry.DependsOn {
+			for _, stepName := range stepNames[name] {
+				dependencies = appendMissing(dependencies, stepName)
 			}
-			pulumi.Printf("Running provisioning script [%s]\n", createName)
-			createResolvedText := templateFileHandler(string(createScriptText))
-			//
-			args := &remote.CommandArgs{
-				Connection: remote.ConnectionArgs{
-					Host:       pulumi.String(settings.DomainName),
-					Port:       pulumi.Float64(22),
-					PrivateKey: pulumi.String(settings.MachineInfo.Credentials.Private),
-					User:       pulumi.String(defaultUser),
-				},
-				Create: pulumi.StringPtr(createResolvedText),
+		}
+		entry.DependsOn = dependencies
+		if entry.Components {
+			componentSteps, err := planComponents(fsys, d, settings, variables, entry, selections)
+			if problems.Collect(err) != nil {
+				return nil, err
 			}
-			// If there is something to when tearing down, add it
-			if entry.Down != "" {
-				deleteScriptText, err := ioutil.ReadFile(entry.Down)
-				if err != nil {
-					return err
-				}
-				deleteResolvedText := templateFileHandler(string(deleteScriptText))
-				args.Delete = pulumi.StringPtr(deleteResolvedText)
+			// the steps waiting for no component wait for what they waited for
+			stepNames[entry.Name] = dependencies
+			if len(componentSteps) > 0 {
+				stepNames[entry.Name] = []string{}
 			}
-			// Run it
-			if cmd, err := remote.NewCommand(ctx, createName, args,
-				pulumi.DependsOn(dependsOns),
-			); err != nil {
-				pulumi.Printf("%s failed\n", createName)
-				if cmd != nil {
-					pulumi.Printf("standard out: %s\n", cmd.Stdout)
-					pulumi.Printf("standard err: %s\n", cmd.Stderr)
-				}
-				return err
-			} else {
-				// Force in order execution
-				dependsOns = append(dependsOns, cmd)
+			for _, step := range componentSteps {
+				stepNames[entry.Name] = append(stepNames[entry.Name], step.Name)
 			}
+			steps = append(steps, componentSteps...)
+			continue
+		}
+		step, err := planStep(fsys, d, engine, entry)
+		if problems.Collect(err) != nil {
+			return nil, err
 		}
+		stepNames[entry.Name] = []string{step.Name}
+		steps = append(steps, step)
 	}
-	return nil
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	steps = sortSteps(steps)
+	names := []string{}
+	for _, step := range steps {
+		names = append(names, step.Name)
+	}
+	for _, setting := range []struct{ path, step string }{
+		{"force_step", settings.ForceStep},
+		{"resume_from", settings.ResumeFrom},
+	} {
+		if setting.step != "" && !contains(names, setting.step) {
+			return nil, fmt.Errorf("settings.%s: no step is named %q, expected one of %s",
+				setting.path, setting.step, strings.Join(names, ", "))
+		}
+	}
+	return steps, nil
 }
 
-func BuildUserData(settings *config.Settings, templateFileHandler func(script string) string) (string, error) {
-	if scripts, err := getUserDataScripts(settings.MachineInfo.OsDist); err != nil {
-		return "", err
-	} else {
-		userDataParts := make([]string, len(scripts))
-		for i, scriptFile := range scripts {
-			if scriptText, err := ioutil.ReadFile(scriptFile); err != nil {
-				return "", err
-			} else {
-				if templateFileHandler != nil {
-					userDataParts[i] = templateFileHandler(string(scriptText))
-				}
-			}
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
-		return strings.Join(userDataParts, "\n###\n"), nil
 	}
+	return false
 }
 
-type ProvisioningSequence struct {
-	Sequence []SeqEntry `yaml:"sequence"`
+// planComponents returns a step per selected component, in order, each
+// taking the retries, timeout, user and environment of the components entry,
+// see componentDependencies
+func planComponents(fsys fs.FS, d distro.Distribution, settings *config.Settings, variables map[string]string, entry SeqEntry, selections []components.Selection) ([]Step, error) {
+	var problems templating.Problems
+	steps := []Step{}
+	dependencies := componentDependencies(entry, selections)
+	for _, selection := range selections {
+		install, uninstall, err := selection.Scripts(fsys, d.ScriptsDir())
+		if err != nil {
+			return nil, err
+		}
+		componentVariables := map[string]string{}
+		for name, value := range variables {
+			componentVariables[name] = value
+		}
+		if selection.VersionVariable != "" {
+			componentVariables[selection.VersionVariable] = selection.Version
+		}
+		componentEntry := entry
+		componentEntry.Name = "component-" + selection.Name
+		componentEntry.Up = install
+		componentEntry.Down = uninstall
+		componentEntry.DependsOn = dependencies[componentEntry.Name]
+		step, err := planStep(fsys, d, templating.New(settings, componentVariables), componentEntry)
+		if problems.Collect(err) != nil {
+			return nil, err
+		}
+		step.Component = selection.Name
+		steps = append(steps, step)
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return steps, nil
 }
 
-type SeqEntry struct {
-	Up   string `yaml:"up"`
-	Down string `yaml:"down"`
+// planStep renders the scripts, user and environment of a step, or its
+// files, for the distribution d
+func planStep(fsys fs.FS, d distro.Distribution, engine *templating.Engine, entry SeqEntry) (Step, error) {
+	var problems templating.Problems
+	var err error
+	step := Step{SeqEntry: entry}
+	if len(entry.Files) > 0 {
+		step.Files, err = planFiles(fsys, engine, entry)
+		return step, err
+	}
+	if entry.Type == TypeAnsible {
+		return planAnsible(fsys, engine, d.PackageManager(), entry)
+	}
+	if step.Create, err = renderFile(fsys, engine, entry.Up); problems.Collect(err) != nil {
+		return step, err
+	}
+	// If there is something to when tearing down, add it
+	if entry.Down != "" {
+		if step.Delete, err = renderFile(fsys, engine, entry.Down); problems.Collect(err) != nil {
+			return step, err
+		}
+	}
+	// the user and environment may use placeholders too
+	source := fmt.Sprintf("sequence.yml (%s)", entry.Name)
+	if step.RunAs, err = engine.Render(source, entry.RunAs); problems.Collect(err) != nil {
+		return step, err
+	}
+	step.Env = map[string]string{}
+	for name, value := range entry.Env {
+		if step.Env[name], err = engine.Render(source, value); problems.Collect(err) != nil {
+			return step, err
+		}
+	}
+	if len(problems) > 0 {
+		return step, problems
+	}
+	return step, nil
 }
 
-func getUserDataScripts(osDist string) ([]string, error) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, err
+// CheckScripts checks the rendered user-data and provisioning scripts, see
+// scriptcheck.Check
+func CheckScripts(userData *UserData, steps []Step) error {
+	var problems templating.Problems
+	for _, part := range userData.Parts {
+		if part.ContentType == "shellscript" || part.ContentType == "boothook" {
+			problems = append(problems, scriptcheck.Check(part.Name, part.Content)...)
+		}
+		if part.ContentType == "cloud-config" {
+			problems = append(problems, scriptcheck.CheckCloudConfig(part.Name, part.Content)...)
+		}
 	}
-	// Relative directory path from project/cmd
-	scriptDir := filepath.Clean(filepath.Join(cwd, fmt.Sprintf("../scripts/%s/userdata", osDist)))
-	entries, err := getScripts(scriptDir)
-	if err != nil {
-		return nil, err
+	for _, step := range steps {
+		if step.Create == "" {
+			continue
+		}
+		problems = append(problems, scriptcheck.Check(step.CreateName(), step.Create)...)
+		if step.Delete != "" {
+			problems = append(problems, scriptcheck.Check(step.DeleteName(), step.Delete)...)
+		}
+	}
+	if len(problems) > 0 {
+		return problems
 	}
-	datascripts := make([]string, len(entries))
-	for i, entry := range entries {
-		if entry.Up != "" {
-			datascripts[i] = entry.Up
+	return nil
+}
+
+// RunProvisioningScripts runs the planned steps on the machine, each one
+// once the steps of its DependsOn succeeded, concurrently otherwise. A step
+// runs again when its Hash changes, on every deploy when it is
+// settings.force_step, and on the next deploy when it failed: the machine
+// keeps the time of its last failure, read before the steps run, see
+// FailuresCommand.
+//
+// The machine keeps a mark of the steps completed, see Command: a step
+// already completed with the same hash is skipped. settings.resume_from runs
+// its step again, and marks the ones it waits for that didn't succeed on the
+// last deploy as completed.
+//
+// The steps connect to host, the public IP of the machine once ready (see
+// ready.Wait) rather than its DNS name, which may not resolve yet.
+//
+// The output of the up script of each step is appended to its log in
+// LogsDir as it completes, along with a summary.json of the results. The
+// down script runs from here over ssh when pulumi deletes the step, even on
+// destroy, appending its output to the same log, see downCommand. A
+// replaced step runs its down script before its up script again. A failed step stops the steps waiting for
+// it, and the state of the machine is collected to LogsDir/diagnostics, see
+// diagnostics.Collect. The returned output resolves to "ok" once every step
+// succeeded.
+//
+// The outputs a step declares are exported as outputs.<name>, from the
+// name=value lines its script writes to $MOB_OUTPUT. The secret ones are
+// encrypted in the stack state and masked in the log.
+func RunProvisioningScripts(ctx *pulumi.Context, settings *config.Settings, host pulumi.StringOutput, steps []Step, dependsOns []pulumi.Resource) (pulumi.StringOutput, error) {
+	connection := remote.ConnectionArgs{
+		Host:       host,
+		Port:       pulumi.Float64(22),
+		PrivateKey: pulumi.String(settings.MachineInfo.Credentials.Private),
+		User:       pulumi.String(settings.MachineInfo.LoginUser),
+	}
+	log := newProvisioningLog(ctx.Stack(), settings, steps)
+	statuses := log.previousStatuses()
+	resumed := map[string]bool{}
+	if settings.ResumeFrom != "" {
+		resumed = waitedFor(steps, settings.ResumeFrom)
+	}
+	failures := host.ApplyT(func(host string) (map[string]string, error) {
+		return previousFailures(ctx, settings, host)
+	}).(pulumi.StringMapOutput)
+	if ctx.DryRun() {
+		pulumi.Printf("Provisioning steps, each one after the ones it waits for (<-):\n%s", Graph(steps))
+	} else if err := log.start(); err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	// each one resolves once the step succeeded, fails otherwise
+	outputs := map[string]pulumi.StringOutput{}
+	resources := map[string][]pulumi.Resource{}
+	for _, step := range steps {
+		step := step
+		createName := step.Name
+		pulumi.Printf("Running provisioning script [%s]\n", createName)
+		forced := ""
+		// the mark of the step on the machine changed before it runs
+		mark := ""
+		if step.Name == settings.ForceStep || step.Name == settings.ResumeFrom {
+			pulumi.Printf("Forcing provisioning script [%s]\n", createName)
+			forced = time.Now().UTC().Format(time.RFC3339Nano)
+			mark = step.UnmarkCommand()
+		} else if resumed[step.Name] && statuses[step.Name] != StatusOk && statuses[step.Name] != StatusSkipped {
+			pulumi.Printf("Skipping provisioning script [%s], resuming from %s\n", createName, settings.ResumeFrom)
+			forced = time.Now().UTC().Format(time.RFC3339Nano)
+			mark = step.MarkCommand()
 		}
+		stepDependsOns := append([]pulumi.Resource{}, dependsOns...)
+		waited := []interface{}{}
+		for _, dependency := range step.DependsOn {
+			stepDependsOns = append(stepDependsOns, resources[dependency]...)
+			waited = append(waited, outputs[dependency])
+		}
+		if mark != "" && step.Create != "" {
+			marked, err := remote.NewCommand(ctx, createName+"-mark", &remote.CommandArgs{
+				Connection: connection,
+				Create:     pulumi.StringPtr(mark),
+				Triggers:   pulumi.Array{pulumi.String(forced)},
+			}, pulumi.DependsOn(dependsOns))
+			if err != nil {
+				return pulumi.StringOutput{}, err
+			}
+			stepDependsOns = append(stepDependsOns, marked)
+			waited = append(waited, marked.Stdout)
+		}
+		after := pulumi.All(waited...).ApplyT(func([]interface{}) string { return "" }).(pulumi.StringOutput)
+		if len(step.Files) > 0 {
+			installed, uploaded, err := uploadFiles(ctx, connection, step, forced, after, stepDependsOns)
+			if err != nil {
+				pulumi.Printf("%s failed\n", createName)
+				return pulumi.StringOutput{}, err
+			}
+			if step.Create == "" {
+				resources[step.Name] = installed
+				outputs[step.Name] = uploaded.ApplyT(func(string) (string, error) {
+					if !ctx.DryRun() {
+						return "", log.recordOk(step.Name)
+					}
+					return "", nil
+				}).(pulumi.StringOutput)
+				continue
+			}
+			// eg. an ansible step, run once its package is in place
+			stepDependsOns, after = installed, uploaded
+		}
+		hash := step.Hash()
+		// the time of the last failure makes a failed step run again
+		triggers := failures.ApplyT(func(failures map[string]string) []interface{} {
+			triggers := []interface{}{hash}
+			if forced != "" {
+				triggers = append(triggers, forced)
+			}
+			if failed := failures[step.Name]; failed != "" {
+				triggers = append(triggers, "failed "+failed)
+			}
+			return triggers
+		}).(pulumi.ArrayOutput)
+		// the down script runs from here, for its output to be logged
+		down, err := downCommand(ctx, settings, host, step, triggers, stepDependsOns)
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		command := step.Command()
+		args := &remote.CommandArgs{
+			Connection: connection,
+			Create:     after.ApplyT(func(string) string { return command }).(pulumi.StringOutput),
+			Triggers:   triggers,
+		}
+		options := []pulumi.ResourceOption{pulumi.DependsOn(append(stepDependsOns, down)), deleteBeforeReplace}
+		secrets := step.secretOutputs()
+		if len(secrets) > 0 {
+			// the stdout holds the values of the secret outputs
+			options = append(options, pulumi.AdditionalSecretOutputs([]string{"stdout"}))
+		}
+		// Run it
+		cmd, err := remote.NewCommand(ctx, createName, args, options...)
+		if err != nil {
+			pulumi.Printf("%s failed\n", createName)
+			return pulumi.StringOutput{}, err
+		}
+		resources[step.Name] = []pulumi.Resource{cmd}
+		exportOutputs(ctx, step, cmd.Stdout)
+		outputs[step.Name] = pulumi.All(cmd.Stdout, host).ApplyT(func(values []interface{}) (string, error) {
+			if ctx.DryRun() {
+				return "", nil
+			}
+			stepOutputs, stdout := parseOutputs(values[0].(string))
+			for _, name := range secrets {
+				if value := stepOutputs[name]; value != "" {
+					stdout = strings.ReplaceAll(stdout, value, config.SecretMask)
+				}
+			}
+			result, err := log.record(step.Name, stdout)
+			if err != nil {
+				return "", err
+			}
+			if result.Status == StatusFailed {
+				return "", fmt.Errorf("%w\n%s", stepError(result), collectDiagnostics(settings, values[1].(string), ctx.Stack(), step.Name, stdout))
+			}
+			return "", nil
+		}).(pulumi.StringOutput)
 	}
-	return datascripts, nil
+	all := []interface{}{}
+	for _, step := range steps {
+		all = append(all, outputs[step.Name])
+	}
+	return pulumi.All(all...).ApplyT(func([]interface{}) string { return StatusOk }).(pulumi.StringOutput), nil
 }
 
-func getProvisioningScripts(osDist string) ([]SeqEntry, error) {
-	cwd, err := os.Getwd()
+// previousFailures returns the time of the last failure of each step that
+// failed on the machine at host, see FailuresCommand. Nothing is returned on
+// preview when the machine can't be reached.
+func previousFailures(ctx *pulumi.Context, settings *config.Settings, host string) (map[string]string, error) {
+	machine, err := ready.NewSSH(settings, host)
 	if err != nil {
 		return nil, err
 	}
-	// Relative directory path from project/cmd
-	scriptDir := filepath.Clean(filepath.Join(cwd, fmt.Sprintf("../scripts/%s/provisioning", osDist)))
-	return getScripts(scriptDir)
+	output, err := machine.Run(FailuresCommand())
+	if err != nil {
+		if ctx.DryRun() {
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("reading the failed steps: %w", err)
+	}
+	return parseFailures(output), nil
 }
 
-func getScripts(directory string) ([]SeqEntry, error) {
-	if _, err := os.Stat(directory); os.IsNotExist(err) {
-		return nil, err
+// deleteBeforeReplace runs the down script of a step replaced (eg. when its
+// triggers change) before its up script runs again. Pulumi creates the
+// replacement first by default: the down script would then undo what the new
+// up script did, eg. remove the go it just installed.
+var deleteBeforeReplace = pulumi.DeleteBeforeReplace(true)
+
+// exportOutputs exports the outputs declared by a step as outputs.<name>,
+// from the lines of its stdout, see parseOutputs
+func exportOutputs(ctx *pulumi.Context, step Step, stdout pulumi.StringOutput) {
+	for _, output := range step.Outputs {
+		name := output.Name
+		value := stdout.ApplyT(func(stdout string) string {
+			outputs, _ := parseOutputs(stdout)
+			return outputs[name]
+		}).(pulumi.StringOutput)
+		if output.Secret {
+			ctx.Export("outputs."+name, pulumi.ToSecret(value))
+		} else {
+			ctx.Export("outputs."+name, value)
+		}
+	}
+}
+
+// collectDiagnostics collects the state of the machine at host after step
+// failed, returning where the bundle is
+func collectDiagnostics(settings *config.Settings, host string, stack string, step string, stdout string) string {
+	machine, err := ready.NewSSH(settings, host)
+	if err == nil {
+		var path string
+		dir := filepath.Join(LogsDir(stack), "diagnostics")
+		if path, err = diagnostics.Collect(machine, settings, dir, step, stdout, time.Now()); err == nil {
+			return "diagnostics: " + path
+		}
 	}
-	orderFile := filepath.Join(directory, "sequence.yml")
-	if _, err := os.Stat(orderFile); os.IsNotExist(err) {
+	return fmt.Sprintf("diagnostics not collected: %v", err)
+}
+
+// BuildUserData renders the user-data scripts whose conditions hold, one
+// MIME part each
+func BuildUserData(settings *config.Settings) (*UserData, error) {
+	fsys, err := Scripts(settings)
+	if err != nil {
 		return nil, err
 	}
-	b, err := ioutil.ReadFile(orderFile)
+	d, err := settings.Distribution()
 	if err != nil {
 		return nil, err
 	}
-	cfg := ProvisioningSequence{}
-	err = yaml.Unmarshal(b, &cfg)
+	scripts, err := getUserDataScripts(fsys, d.ScriptsDir())
 	if err != nil {
 		return nil, err
 	}
-	files := make([]SeqEntry, len(cfg.Sequence))
-	for i, entry := range cfg.Sequence {
-		if entry.Up == "" {
+	engine := templating.New(settings, Variables(settings))
+	var problems templating.Problems
+	userData := &UserData{}
+	for _, entry := range scripts {
+		if !entry.runs(settings) {
 			continue
 		}
-		item := SeqEntry{
-			Up: filepath.Clean(filepath.Join(directory, entry.Up)),
-		}
-		if _, err := os.Stat(item.Up); os.IsNotExist(err) {
+		content, err := renderFile(fsys, engine, entry.Up)
+		if problems.Collect(err) != nil {
 			return nil, err
 		}
-		if entry.Down != "" {
-			item.Down = filepath.Clean(filepath.Join(directory, entry.Down))
-			if _, err := os.Stat(item.Down); os.IsNotExist(err) {
-				return nil, err
-			}
+		userData.Parts = append(userData.Parts, Part{Name: entry.Name, ContentType: entry.ContentType, Content: content})
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return userData, nil
+}
+
+// renderFile renders a script of the scripts tree, named after its file in
+// problems
+func renderFile(fsys fs.FS, engine *templating.Engine, file string) (string, error) {
+	text, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return "", err
+	}
+	return engine.Render(path.Base(file), string(text))
+}
+
+func getUserDataScripts(fsys fs.FS, distDir string) ([]SeqEntry, error) {
+	scriptDir := path.Join(distDir, "userdata")
+	entries, err := getScripts(fsys, scriptDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Components || len(entry.Requires) > 0 || len(entry.Files) > 0 || entry.Group != "" || len(entry.After) > 0 ||
+			len(entry.Outputs) > 0 || entry.Type == TypeAnsible {
+			return nil, fmt.Errorf("%s: step %s: components, requires, files, group, after, outputs and type ansible are only for provisioning steps",
+				path.Join(scriptDir, "sequence.yml"), entry.Name)
 		}
-		files[i] = item
 	}
-	return files, nil
+	if err := orderEntries(entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", path.Join(scriptDir, "sequence.yml"), err)
+	}
+	return entries, nil
+}
+
+func getProvisioningScripts(fsys fs.FS, distDir string) ([]SeqEntry, error) {
+	scriptDir := path.Join(distDir, "provisioning")
+	entries, err := getScripts(fsys, scriptDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.ContentType != "shellscript" {
+			return nil, fmt.Errorf("%s: step %s: content_type and type cloud-config are only for user-data, provisioning steps are shell scripts or ansible playbooks",
+				path.Join(scriptDir, "sequence.yml"), entry.Name)
+		}
+	}
+	if err := orderEntries(entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", path.Join(scriptDir, "sequence.yml"), err)
+	}
+	return entries, nil
 }
@@ -0,0 +1,213 @@
+package userdata
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/slim-ai/mob-code-server/pkg/templating"
+)
+
+// markersDir holds a file per step completed on the machine, named after
+// the step and holding its Hash, in the home directory of the login user
+const markersDir = "$HOME/.mob-server/steps"
+
+// outputsDir keeps the outputs of the steps completed on the machine, for
+// them to be reported again when the step is skipped
+const outputsDir = "$HOME/.mob-server/outputs"
+
+// failuresDir holds a file per step that failed on the machine, named after
+// the step and holding the time of its last failure. It is kept once the step
+// succeeds, for the time of a new failure to differ, see FailuresCommand.
+const failuresDir = "$HOME/.mob-server/failed"
+
+// wrapperTemplate runs a step script on the machine, as the step user and
+// environment, retrying with backoff. The script is shipped base64 encoded
+// so that it doesn't need any quoting. Its output ends with a status line,
+// see parseResult. The script writes its outputs to $MOB_OUTPUT, reported
+// before the status line, see parseOutputs.
+const wrapperTemplate = `set -u
+exec 2>&1
+%sscript=$(mktemp /tmp/mob-step.XXXXXX)
+outputs=$(mktemp /tmp/mob-outputs.XXXXXX)
+trap 'rm -f "$script" "$outputs"' EXIT
+echo '%s' | base64 -d > "$script"
+chmod 755 "$script"
+%sstarted=$(date +%%s)
+attempt=0
+delay=%d
+while true; do
+    attempt=$((attempt + 1))
+    %s && status=0 || status=$?
+    if [ "$status" -eq 0 ] || [ "$attempt" -gt %d ]; then
+        break
+    fi
+    echo "step %s failed with status $status, retrying in ${delay}s ($attempt/%d)"
+    sleep "$delay"
+    delay=$((delay * 2))
+done
+[ "$status" -eq 0 ] || echo "step %s failed with status $status"
+%ssed 's/^/` + outputPrefix + `/' "$outputs"
+%secho "` + statusPrefix + `status=$status attempts=$attempt seconds=$(($(date +%%s) - started)) started=$started"
+exit %s
+`
+
+// markerTemplate skips the step when it is marked as completed with the
+// same hash, reporting 0 attempts
+const markerTemplate = `marker=%s
+saved=%s
+failed=%s
+hash=%s
+if [ "$(cat "$marker" 2>/dev/null)" = "$hash" ]; then
+    echo "step %s already completed on this machine, skipped"
+    [ ! -f "$saved" ] || sed 's/^/` + outputPrefix + `/' "$saved"
+    echo "` + statusPrefix + `status=0 attempts=0 seconds=0 started=$(date +%%s)"
+    exit 0
+fi
+rm -f "$marker" "$saved"
+`
+
+// completedTemplate marks the step as completed once it succeeded, keeping
+// its outputs, and keeps the time it failed otherwise
+const completedTemplate = `if [ "$status" -eq 0 ]; then
+    mkdir -p "$(dirname "$marker")" "$(dirname "$saved")" && echo "$hash" > "$marker" && cp "$outputs" "$saved"
+else
+    mkdir -p "$(dirname "$failed")" && date -u +%Y-%m-%dT%H:%M:%S.%NZ > "$failed"
+fi
+`
+
+// unmarkTemplate removes the mark of the step and its outputs once the down
+// script succeeded, unless they belong to another version of the step
+const unmarkTemplate = `if [ "$status" -eq 0 ] && [ "$(cat %s 2>/dev/null)" = %s ]; then
+    rm -f %s %s
+fi
+`
+
+// Command returns the command running the up script on the machine. It
+// succeeds even when the script fails, for the output to be logged, see
+// RunProvisioningScripts; the time of the failure is kept in failuresDir for
+// the step to run again. Once the script succeeded, the step is marked as
+// completed in markersDir, and skipped while the mark holds its Hash.
+func (step Step) Command() string {
+	saved := outputsDir + "/" + templating.Quote(step.Name)
+	failed := failuresDir + "/" + templating.Quote(step.Name)
+	marker := fmt.Sprintf(markerTemplate, step.marker(), saved, failed, step.Hash(), step.Name)
+	return step.wrap(step.Create, step.Retries, "0", marker, completedTemplate)
+}
+
+// MarkCommand returns the command marking the step as completed without
+// running it, eg. before settings.resume_from
+func (step Step) MarkCommand() string {
+	return fmt.Sprintf("mkdir -p %s && echo %s > %s\n", markersDir, step.Hash(), step.marker())
+}
+
+// UnmarkCommand returns the command removing the mark of the step, for it
+// to run again, eg. when forced
+func (step Step) UnmarkCommand() string {
+	return fmt.Sprintf("rm -f %s\n", step.marker())
+}
+
+// FailuresCommand returns the command listing the steps that failed on the
+// machine, a <step>:<time> line each, see parseFailures
+func FailuresCommand() string {
+	return fmt.Sprintf("cd %s 2>/dev/null && grep -H '' -- * || true\n", failuresDir)
+}
+
+// secretOutputs returns the names of the secret outputs of the step
+func (step Step) secretOutputs() []string {
+	names := []string{}
+	for _, output := range step.Outputs {
+		if output.Secret {
+			names = append(names, output.Name)
+		}
+	}
+	return names
+}
+
+func (step Step) marker() string {
+	return markersDir + "/" + templating.Quote(step.Name)
+}
+
+// Hash identifies what the step runs: its scripts once rendered, user,
+// environment, retries and timeout
+func (step Step) Hash() string {
+	sum := sha256.Sum256([]byte(step.wrap(step.Create, step.Retries, "0", "", "") + "\x00" + step.down("")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Fingerprint identifies the planned steps, changing when any of them runs
+// again because its Hash changed
+func Fingerprint(steps []Step) string {
+	sum := sha256.New()
+	for _, step := range steps {
+		fmt.Fprintf(sum, "%s\x00%s\x00", step.Name, step.Hash())
+		for _, file := range step.Files {
+			fmt.Fprintf(sum, "%s\x00", file.hash())
+		}
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// DeleteCommand returns the command running the down script, once, then
+// removing the mark of the step for it to run again when created again. A
+// step without down script only has its mark removed.
+func (step Step) DeleteCommand() string {
+	marker := step.marker()
+	unmark := fmt.Sprintf(unmarkTemplate, marker, step.Hash(), marker, outputsDir+"/"+templating.Quote(step.Name))
+	if step.Delete == "" {
+		return "status=0\n" + unmark
+	}
+	return step.down(unmark)
+}
+
+// down returns the wrapper of the down script, running after once it
+// completed, if any
+func (step Step) down(after string) string {
+	if step.Delete == "" {
+		return ""
+	}
+	return step.wrap(step.Delete, 0, `"$status"`, "", after)
+}
+
+// wrap returns the wrapper of script, starting with before and running
+// after once it completed
+func (step Step) wrap(script string, retries int, exit string, before string, after string) string {
+	chown := ""
+	// the outputs file back to the login user, readable by it only
+	reclaim := ""
+	run := []string{}
+	if step.RunAs != "" {
+		chown = fmt.Sprintf("sudo chown %s \"$script\" \"$outputs\"\n", templating.Quote(step.RunAs))
+		reclaim = "sudo chown \"$(id -un)\" \"$outputs\"\n"
+		run = append(run, "sudo", "-H", "-u", templating.Quote(step.RunAs))
+	}
+	names := make([]string, 0, len(step.Env))
+	for name := range step.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	run = append(run, "env", `MOB_OUTPUT="$outputs"`)
+	for _, name := range names {
+		run = append(run, templating.Quote(name+"="+step.Env[name]))
+	}
+	if step.TimeoutDuration > 0 {
+		run = append(run, "timeout", fmt.Sprint(int(step.TimeoutDuration.Seconds())))
+	}
+	run = append(run, "bash", `"$script"`)
+	return fmt.Sprintf(wrapperTemplate,
+		before,
+		base64.StdEncoding.EncodeToString([]byte(script)),
+		chown,
+		int(step.BackoffDuration.Seconds()),
+		strings.Join(run, " "),
+		retries,
+		step.Name, retries,
+		step.Name,
+		reclaim,
+		after,
+		exit,
+	)
+}
@@ -0,0 +1,14 @@
+#!/usr/bin/env bash
+#
+# Installs the session-manager-plugin component on amazon linux, see
+# pkg/components
+
+install_session_manager_plugin() {
+    local platform=linux_64bit
+    if [ "$(uname -m)" = "aarch64" ]; then
+        platform=linux_arm64
+    fi
+    sudo dnf install -y "https://s3.amazonaws.com/session-manager-downloads/plugin/latest/$platform/session-manager-plugin.rpm"
+}
+
+install_session_manager_plugin
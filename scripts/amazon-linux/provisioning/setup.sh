@@ -0,0 +1,148 @@
+#!/usr/bin/env bash
+#
+# Each installation is a script function
+# and the sequence is defined at the bottom of the file.
+#
+# The amazon linux counterpart of ubuntu/provisioning/setup.sh. The
+# toolchains are installed by the components steps that follow,
+# see pkg/components.
+
+# add_user_to_docker_group "username"
+add_user_to_docker_group() {
+    local username=$1
+    sudo usermod -aG docker $username
+}
+
+# install_caddy "domain_name" "email_address" "username"
+install_caddy() {
+    local domain_name=$1
+    local email_address=$2
+    local username=$3
+    # Install caddy from its copr repository
+    sudo dnf install -y 'dnf-command(copr)'
+    sudo dnf copr enable -y @caddy/caddy epel-9-$(uname -m)
+    sudo dnf install -y caddy
+    sudo systemctl enable --now caddy
+
+    # Obtain cert.
+    sudo python3 -m venv /opt/certbot
+    sudo /opt/certbot/bin/pip install --upgrade pip certbot
+    sudo ln -sf /opt/certbot/bin/certbot /usr/bin/certbot
+    sudo certbot certonly --noninteractive --agree-tos --no-eff-email --cert-name $domain_name --no-redirect -d  $domain_name -m $email_address --webroot -w /usr/share/caddy/
+
+    echo "$domain_name" | sudo tee /etc/caddy/Caddyfile
+    echo "tls $email_address" | sudo tee -a  /etc/caddy/Caddyfile
+    echo "reverse_proxy 127.0.0.1:8080" | sudo tee -a  /etc/caddy/Caddyfile
+
+    sudo systemctl reload caddy
+
+    sudo systemctl restart code-server@$username
+}
+
+# install_code_server "username"
+install_code_server() {
+    local username=$1
+    # install code-server service system-wide
+    export HOME=/root
+    curl -fsSL https://code-server.dev/install.sh | sudo sh
+
+    # create a code-server user
+    if ! id "$username" >/dev/null 2>&1; then
+        sudo useradd --create-home --shell /bin/bash $username
+    fi
+    echo "$username ALL=(ALL:ALL) NOPASSWD: ALL" | sudo tee /etc/sudoers.d/$username
+    sudo usermod -aG wheel $username
+
+    # copy ssh keys from root
+    sudo cp -r /root/.ssh /home/$username/.ssh
+    sudo chown -R $username:$username /home/$username/.ssh
+
+    # configure code-server to use --link with the "coder" user
+    sudo mkdir -p /home/$username/.config/code-server
+
+    PASSWD=$(date +%s | sha256sum | base64 | head -c 16 ; echo)
+    CODESERVER_CONFIG="/home/$username/.config/code-server/config.yaml"
+    echo "disable-telemetry: true" | sudo tee ${CODESERVER_CONFIG}
+    echo "auth: password" | sudo tee -a ${CODESERVER_CONFIG}
+    echo "password: ${PASSWD}" | sudo tee -a ${CODESERVER_CONFIG}
+    # stack outputs, see sequence.yml
+    echo "code_server_password=${PASSWD}" >> "$MOB_OUTPUT"
+    echo "code_server_version=$(code-server --version | head -n 1 | cut -d ' ' -f 1)" >> "$MOB_OUTPUT"
+
+    sudo chown -R $username:$username /home/$username/.config
+
+    # start and enable code-server and our helper service
+    sudo systemctl enable --now code-server@$username
+}
+
+# install_packages
+install_packages(){
+    # PACKER
+    sudo dnf install -y dnf-plugins-core
+    sudo dnf config-manager --add-repo https://rpm.releases.hashicorp.com/AmazonLinux/hashicorp.repo
+
+    sudo dnf upgrade -y
+
+    # Install packages
+    sudo dnf install -y \
+        ca-certificates \
+        make \
+        git \
+        gcc \
+        unzip \
+        jq \
+        python3 \
+        docker \
+        java-17-amazon-corretto-headless \
+        packer
+    sudo systemctl enable --now docker
+
+    # the docker compose plugin isn't packaged
+    sudo mkdir -p /usr/local/lib/docker/cli-plugins
+    sudo curl -fsSL "https://github.com/docker/compose/releases/latest/download/docker-compose-linux-$(uname -m)" \
+        -o /usr/local/lib/docker/cli-plugins/docker-compose
+    sudo chmod +x /usr/local/lib/docker/cli-plugins/docker-compose
+
+    # yq v3, as snap installs it on ubuntu
+    local arch=amd64
+    if [ "$(uname -m)" = "aarch64" ]; then
+        arch=arm64
+    fi
+    sudo curl -fsSL "https://github.com/mikefarah/yq/releases/download/3.4.1/yq_linux_$arch" -o /usr/local/bin/yq
+    sudo chmod +x /usr/local/bin/yq
+}
+
+# set_hostname "new_hostname" "username"
+function set_hostname() {
+    hostname=$1
+    username=$2
+    sudo hostnamectl set-hostname $hostname
+    sudo systemctl restart code-server@$username
+}
+
+# set_def_vars "username"
+set_def_vars() {
+    local username=$1
+    local line
+    # once, when the step runs again
+    for line in \
+        'export PATH=${PATH}:/usr/local/bin' \
+        'export PATH=${PATH}:${HOME}/bin' \
+        "export SAI_ENV_TYPE=local" \
+        "export SAI_ENV_NAME=local" \
+        "export SAI_ENV_ROLE=local"; do
+        sudo grep -qxF "$line" /home/$username/.bashrc ||
+            echo "$line" | sudo -u $username tee -a /home/$username/.bashrc
+    done
+}
+
+# Installation Sequence
+# These variables are replaced by the pulumi automation
+# before writing the file to the remote machine then running it.
+install_packages
+# Creates User
+install_code_server "___USERNAME___"
+install_caddy "___DOMAIN_NAME___" "___EMAIL__ADDRESS___" "___USERNAME___"
+add_user_to_docker_group "___USERNAME___"
+set_hostname "___HOSTNAME___" "___USERNAME___"
+set_def_vars "___USERNAME___"
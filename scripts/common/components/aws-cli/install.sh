@@ -0,0 +1,19 @@
+#!/usr/bin/env bash
+#
+# Installs the aws-cli component, see pkg/components
+
+# install_aws_cli
+install_aws_cli() {
+    (
+        cd /tmp
+        # x86_64 or aarch64
+        sudo curl "https://awscli.amazonaws.com/awscli-exe-linux-$(uname -m).zip" -o "awscliv2.zip"
+        sudo unzip awscliv2.zip
+        sudo ./aws/install
+        # Clean up
+        sudo rm -f awscliv2.zip
+        sudo rm -rf aws
+    )
+}
+
+install_aws_cli
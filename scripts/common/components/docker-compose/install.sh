@@ -0,0 +1,17 @@
+#!/usr/bin/env bash
+#
+# Installs the docker-compose component, see pkg/components
+
+# install_docker_compose
+install_docker_compose() {
+    # Create the file
+    cat > /tmp/docker-compose <<EOL
+    #!/bin/bash
+    docker compose \$@
+EOL
+    # Make the file executable
+    chmod a+x /tmp/docker-compose
+    sudo mv /tmp/docker-compose /usr/local/bin/docker-compose
+}
+
+install_docker_compose
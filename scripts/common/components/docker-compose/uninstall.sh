@@ -0,0 +1,5 @@
+#!/usr/bin/env bash
+#
+# Removes the docker-compose component, see pkg/components
+
+sudo rm -f /usr/local/bin/docker-compose
@@ -0,0 +1,15 @@
+#!/usr/bin/env bash
+#
+# Installs the git-secret component, see pkg/components
+
+# install_git_secret
+install_git_secret() {
+    (
+        cd /tmp
+        sudo git clone https://github.com/sobolevn/git-secret.git git-secret
+        cd git-secret && sudo make build
+        sudo PREFIX="/usr/local" make install
+    )
+}
+
+install_git_secret
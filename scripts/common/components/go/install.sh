@@ -0,0 +1,90 @@
+#!/usr/bin/env bash
+#
+# Installs the go component, see pkg/components
+# @var GOPRIVATE default=
+
+# golang "username" "1.17" "private.com"
+install_go() {
+    local username=$1
+    local version=$2
+    local go_private=$3
+    local os
+    local arch
+    local platform
+    local package_name
+    local temp_directory
+    local shell_profile="/home/$username/.bashrc"
+    os="$(uname -s)"
+    arch="$(uname -m)"
+
+    case $os in
+        "Linux")
+            case $arch in
+            "x86_64")
+                arch=amd64
+                ;;
+            "aarch64")
+                arch=arm64
+                ;;
+            "armv6" | "armv7l")
+                arch=armv6l
+                ;;
+            "armv8")
+                arch=arm64
+                ;;
+            .*386.*)
+                arch=386
+                ;;
+            esac
+            platform="linux-$arch"
+        ;;
+        "Darwin")
+            platform="darwin-amd64"
+        ;;
+    esac
+
+    if [ -z "$platform" ]; then
+        echo "Your operating system is not supported by the script."
+        exit 1
+    fi
+
+    package_name="go$version.$platform.tar.gz"
+    temp_directory=$(mktemp -d)
+
+    echo "Downloading $package_name ..."
+    if hash wget 2>/dev/null; then
+        wget -q https://storage.googleapis.com/golang/$package_name -O "$temp_directory/go.tar.gz"
+    else
+        curl -s -o "$temp_directory/go.tar.gz" https://storage.googleapis.com/golang/$package_name
+    fi
+
+    if [ $? -ne 0 ]; then
+        echo "Download failed! Exiting."
+        exit 1
+    fi
+
+    echo "Extracting File..."
+    sudo -u $username mkdir -p "/home/$username/go"
+    sudo mkdir -p "/usr/local/go/src"
+    sudo mkdir -p "/usr/local/go/bin"
+    sudo mkdir -p "/usr/local/go/pkg"
+    sudo chmod -R a+rx "/usr/local/go"
+    sudo rm -rf /usr/local/go && sudo tar -C /usr/local -xzf "$temp_directory/go.tar.gz"
+
+    local line
+    # once, when the step runs again
+    for line in \
+        "export GOROOT=/usr/local/go" \
+        "export GOPATH=/home/$username/go" \
+        'export PATH=$GOROOT/bin:$GOPATH/bin:$PATH'; do
+        sudo grep -qxF "$line" /home/$username/.bashrc ||
+            echo "$line" | sudo -u $username tee -a /home/$username/.bashrc
+    done
+
+    echo -e "\nGo $version was installed into $GOROOT.\nMake sure to relogin into your shell or run:"
+    echo -e "\n\tsource $shell_profile\n\nto update your environment variables."
+    echo "Tip: Opening a new terminal window usually just works. :)"
+    sudo rm -f "$temp_directory/go.tar.gz"
+}
+
+install_go "___USERNAME___" "___GOLANG_VERSION___" "___GOPRIVATE___"
@@ -0,0 +1,12 @@
+#!/usr/bin/env bash
+#
+# Removes the go component, see pkg/components
+
+# uninstall_go "username"
+uninstall_go() {
+    local username=$1
+    sudo rm -rf /usr/local/go
+    sudo sed -i '/^export GOROOT=/d;/^export GOPATH=/d;/^export PATH=\$GOROOT\/bin:\$GOPATH\/bin:\$PATH$/d' /home/$username/.bashrc
+}
+
+uninstall_go "___USERNAME___"
@@ -0,0 +1,23 @@
+#!/usr/bin/env bash
+#
+# Installs the nvm component, see pkg/components
+
+# install_nvm "username" "0.39.3"
+install_nvm() {
+  local username=$1
+  local nvm_version=$2
+
+  # add user if it doesn't exist
+  if ! id "$username" >/dev/null 2>&1; then
+    useradd "$username"
+  fi
+  (
+    cd /home/$username
+    # install nvm as user
+    sudo -u $username curl -o- https://raw.githubusercontent.com/nvm-sh/nvm/v${nvm_version}/install.sh | sudo -u $username bash
+    sudo -u $username bash /home/$username/.nvm/nvm.sh    
+    echo "nvm install v16.19.0" | sudo -u $username tee -a /home/$username/.bashrc
+  )
+}
+
+install_nvm "___USERNAME___" "___NVM_VERSION___"
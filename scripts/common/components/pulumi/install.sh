@@ -0,0 +1,11 @@
+#!/usr/bin/env bash
+#
+# Installs the pulumi component, see pkg/components
+
+# install_pulumi "username""
+install_pulumi() {
+    username=$1
+    curl -fsSL https://get.pulumi.com | sudo -u $username sh
+}
+
+install_pulumi "___USERNAME___"
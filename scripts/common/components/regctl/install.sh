@@ -0,0 +1,11 @@
+#!/usr/bin/env bash
+#
+# Installs the regctl component, see pkg/components
+
+# install_regctl "username"
+install_regctl() {
+    username=$1
+    sudo -u $username /usr/local/go/bin/go install github.com/regclient/regclient/cmd/regctl@latest@latest
+}
+
+install_regctl "___USERNAME___"
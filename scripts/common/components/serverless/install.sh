@@ -0,0 +1,12 @@
+#!/usr/bin/env bash
+#
+# Installs the serverless component, see pkg/components
+
+# install_serverless "2.64.1" "username"
+install_serverless() {
+    version=$1
+    username=$2
+    curl -o- -L https://slss.io/install | sudo -u $username VERSION=$version bash
+}
+
+install_serverless "___SERVERLESS_VERSION___" "___USERNAME___"
@@ -0,0 +1,20 @@
+#!/usr/bin/env bash
+#
+# Installs the session-manager-plugin component, see pkg/components
+
+install_session_manager_plugin() {
+    local platform=ubuntu_64bit
+    if [ "$(dpkg --print-architecture)" = "arm64" ]; then
+        platform=ubuntu_arm64
+    fi
+    # Download the session manager plugin
+    curl "https://s3.amazonaws.com/session-manager-downloads/plugin/latest/$platform/session-manager-plugin.deb" -o "session-manager-plugin.deb"
+
+    # Install the plugin
+    sudo dpkg -i session-manager-plugin.deb
+
+    # Clean up the downloaded file
+    rm session-manager-plugin.deb
+}
+
+install_session_manager_plugin
@@ -0,0 +1,10 @@
+#!/usr/bin/env bash
+#
+# Installs the terraform component, see pkg/components
+
+# install_terraform_switcher
+install_terraform_switcher() {
+    curl -L https://raw.githubusercontent.com/warrensbox/terraform-switcher/release/install.sh | sudo bash
+}
+
+install_terraform_switcher
@@ -0,0 +1,91 @@
+#!/usr/bin/env bash
+#
+# Generates the machine ssh key, registers it with gitlab and checks out
+# the repositories, once the go component installed gitadm's toolchain.
+
+# git_ssh_key "username"
+git_ssh_key() {
+    local username=$1
+    sudo -u $username mkdir -p /home/$username/.ssh
+    # Generate an SSH key, unless there is one
+    if [ ! -f /home/$username/.ssh/id_ed25519 ]; then
+        sudo -u $username ssh-keygen -t ed25519 -f /home/$username/.ssh/id_ed25519 -q -N ""
+    fi
+    # stack output, see sequence.yml
+    echo "git_ssh_key_fingerprint=$(ssh-keygen -lf /home/$username/.ssh/id_ed25519.pub | cut -d ' ' -f 2)" >> "$MOB_OUTPUT"
+}
+
+# add_git_ssh "username" "gitlab_token" "domain_name" "email_address"
+add_git_ssh() {
+    local username=$1
+    local gitlab_token=$2
+    local domain_name=$3
+    local email_address=$4
+    (
+        # Change to the user's home directory
+        cd /home/$username/
+        git_ssh_key $username
+
+        # Pull down gitadm helper
+        sudo -u $username /usr/local/go/bin/go install github.com/slimdevl/gitadm@latest
+
+        # Push the key to gitlab
+        sudo -u $username /home/$username/go/bin/gitadm --token="$gitlab_token" \
+        add ssh-key --title "$domain_name" --overwrite=true --file /home/$username/.ssh/id_ed25519.pub
+
+        # Setup private org
+        GOPRIVATE_ORGS=$(sudo -u $username /home/$username/go/bin/gitadm describe orgs --short)
+        # once, when the step runs again
+        sudo grep -qxF "GOPRIVATE=${GOPRIVATE_ORGS}" /home/$username/.bashrc ||
+            echo "GOPRIVATE=${GOPRIVATE_ORGS}" | sudo -u $username tee -a /home/$username/.bashrc
+
+        # Setup the git config
+        cat > /tmp/.gitconfig <<EOF
+    [user]
+        email = $email_address
+    # Enforce SSH
+    [url "ssh://git@github.com/"]
+        insteadOf = https://github.com/
+    [url "ssh://git@gitlab.com/"]
+        insteadOf = https://gitlab.com/
+EOF
+        sudo chown $username:$username /tmp/.gitconfig
+        sudo mv /tmp/.gitconfig /home/$username/.gitconfig
+    )
+}
+
+# setup_git_repos "username" "gitlab_repos"
+setup_git_repos() {
+    local username=$1
+    local gitlab_repos=$2
+    (
+        # Change to the user's home directory
+        cd /home/$username
+        # once, when the step runs again
+        sudo grep -qxF "Host *" /home/$username/.ssh/config 2>/dev/null ||
+            echo -e "Host *\n\tStrictHostKeyChecking no" | sudo -u $username tee -a /home/$username/.ssh/config
+        sudo -u $username mkdir -p code
+        cd code
+        echo "$gitlab_repos" | sudo -u $username tee repo.list
+        # Now check out all the
+        variable="$gitlab_repos"
+        for i in $(echo $variable | sed "s/,/ /g")
+        do
+            # already checked out when the step runs again
+            if [ -d "$(basename "$i" .git)" ]; then
+                echo "$i is already checked out"
+                continue
+            fi
+            sudo -u $username git clone $i
+        done
+    )
+}
+
+{% if .Settings.Gitlab.Enabled %}
+add_git_ssh "___USERNAME___" "___GITLAB_TOKEN___" "___DOMAIN_NAME___" "___EMAIL__ADDRESS___"
+setup_git_repos "___USERNAME___" "___GITLAB_REPOS___"
+{% end %}
+{% if .Settings.Github.Enabled %}
+git_ssh_key "___USERNAME___"
+setup_git_repos "___USERNAME___" "___GITHUB_REPOS___"
+{% end %}
@@ -8,4 +8,6 @@ remove_ssh_key() {
     sudo -u $username /home/$username/go/bin/gitadm rm ssh-key --title "$domain_name"
 }
 
-remove_ssh_key "___USERNAME___" "___DOMAIN_NAME___"
\ No newline at end of file
+{% if .Settings.Gitlab.Enabled %}
+remove_ssh_key "___USERNAME___" "___DOMAIN_NAME___"
+{% end %}
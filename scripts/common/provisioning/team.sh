@@ -0,0 +1,69 @@
+#!/usr/bin/env bash
+#
+# Gives each member of the team their own account, code-server
+# instance and Caddy route. The calls at the bottom of the file
+# are generated from the `team` settings.
+
+# add_team_member "username" "port" "git_name" "git_email" "ssh_key"...
+add_team_member() {
+    local username=$1
+    local port=$2
+    local git_name=$3
+    local git_email=$4
+    shift 4
+
+    if ! id "$username" >/dev/null 2>&1; then
+        sudo useradd --create-home --shell /bin/bash $username
+    fi
+    echo "$username ALL=(ALL:ALL) NOPASSWD: ALL" | sudo tee /etc/sudoers.d/$username
+    # sudo on debian and ubuntu, wheel on amazon linux
+    if getent group sudo >/dev/null; then
+        sudo usermod -aG sudo $username
+    else
+        sudo usermod -aG wheel $username
+    fi
+    if getent group docker >/dev/null; then
+        sudo usermod -aG docker $username
+    fi
+
+    # authorize the member's own keys
+    sudo -u $username mkdir -p /home/$username/.ssh
+    sudo chmod 700 /home/$username/.ssh
+    printf '%s\n' "$@" | sudo -u $username tee /home/$username/.ssh/authorized_keys
+    sudo chmod 600 /home/$username/.ssh/authorized_keys
+
+    # git identity
+    sudo -u $username git config --global user.name "$git_name"
+    sudo -u $username git config --global user.email "$git_email"
+
+    # code-server on the member's own port, keeping any existing password
+    local config_file="/home/$username/.config/code-server/config.yaml"
+    local password
+    password=$(sudo sed -n 's/^password: //p' $config_file 2>/dev/null)
+    if [ -z "$password" ]; then
+        password=$(head -c 32 /dev/urandom | sha256sum | base64 | head -c 16)
+    fi
+    sudo -u $username mkdir -p /home/$username/.config/code-server
+    echo "bind-addr: 127.0.0.1:$port" | sudo -u $username tee $config_file
+    echo "disable-telemetry: true" | sudo -u $username tee -a $config_file
+    echo "auth: password" | sudo -u $username tee -a $config_file
+    echo "password: $password" | sudo -u $username tee -a $config_file >/dev/null
+
+    sudo systemctl enable code-server@$username
+    sudo systemctl restart code-server@$username
+}
+
+# write_caddyfile "email_address" "domain_name:port"...
+write_caddyfile() {
+    local email_address=$1
+    shift
+    local site
+    echo -n | sudo tee /etc/caddy/Caddyfile
+    for site in "$@"; do
+        printf '%s {\n    tls %s\n    reverse_proxy 127.0.0.1:%s\n}\n\n' \
+            "${site%:*}" "$email_address" "${site##*:}" | sudo tee -a /etc/caddy/Caddyfile
+    done
+    sudo systemctl reload caddy
+}
+
+___TEAM_MEMBERS___
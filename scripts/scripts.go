@@ -0,0 +1,11 @@
+// Package scripts holds the built-in provisioning scripts, embedded in the
+// binary: common/ for every distribution and a directory per family of
+// distributions, see distro.Distribution.ScriptsDir.
+package scripts
+
+import "embed"
+
+// FS is the scripts tree, eg. ubuntu/provisioning/sequence.yml
+//
+//go:embed common ubuntu amazon-linux
+var FS embed.FS